@@ -0,0 +1,164 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ */
+
+package mutators
+
+import (
+	"fmt"
+	"sort"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// appliedDefaultPoliciesAnnotation records, for auditability, the names of
+// the VirtualMachineDefaultPolicy objects whose defaults were applied to a
+// VM by the default-policy mutation stage.
+const appliedDefaultPoliciesAnnotation = "defaultpolicy.kubevirt.io/applied-policies"
+
+// VirtualMachineSpecDefaults is the partial VirtualMachineSpec a
+// VirtualMachineDefaultPolicy patches onto fields a user did not set.
+type VirtualMachineSpecDefaults struct {
+	MachineType       string
+	CPUModel          string
+	NodeSelector      map[string]string
+	Tolerations       []k8sv1.Toleration
+	PriorityClassName string
+	StorageClass      string
+	NetworkSource     *v1.NetworkSource
+}
+
+// VirtualMachineDefaultPolicy is a cluster- or namespace-scoped rule that
+// applies Defaults to every VirtualMachine whose labels match Selector.
+// Namespace is empty for the cluster-scoped variant.
+type VirtualMachineDefaultPolicy struct {
+	Name      string
+	Namespace string
+	// Priority breaks ties when more than one policy matches and sets the
+	// same field; the highest priority wins. Equal priorities fall back to
+	// name ordering for determinism.
+	Priority int
+	Selector *metav1.LabelSelector
+	Defaults VirtualMachineSpecDefaults
+}
+
+// DefaultPolicyLister returns the VirtualMachineDefaultPolicy objects
+// visible to the mutator. A nil DefaultPolicyLister on a VMsMutator
+// disables the default-policy stage entirely.
+type DefaultPolicyLister interface {
+	List() ([]VirtualMachineDefaultPolicy, error)
+}
+
+func (mutator *VMsMutator) applyDefaultPoliciesStage(vm *v1.VirtualMachine, _ *MutationContext) error {
+	if mutator.DefaultPolicyLister == nil || vm.Spec.Template == nil {
+		return nil
+	}
+
+	policies, err := mutator.DefaultPolicyLister.List()
+	if err != nil {
+		return err
+	}
+
+	matching, err := matchingPolicies(vm, policies)
+	if err != nil {
+		return err
+	}
+	if len(matching) == 0 {
+		return nil
+	}
+
+	var applied []string
+	for _, policy := range matching {
+		applyVirtualMachineSpecDefaults(vm, policy.Defaults)
+		applied = append(applied, policy.Name)
+	}
+
+	if vm.Annotations == nil {
+		vm.Annotations = map[string]string{}
+	}
+	vm.Annotations[appliedDefaultPoliciesAnnotation] = fmt.Sprintf("%v", applied)
+
+	return nil
+}
+
+// matchingPolicies returns the policies whose selector matches vm, ordered
+// highest to lowest precedence: by Priority descending, then by Name
+// ascending. applyVirtualMachineSpecDefaults only fills fields that are
+// still unset, so the first entry in the slice that sets a field wins.
+func matchingPolicies(vm *v1.VirtualMachine, policies []VirtualMachineDefaultPolicy) ([]VirtualMachineDefaultPolicy, error) {
+	var matching []VirtualMachineDefaultPolicy
+	for _, policy := range policies {
+		if policy.Namespace != "" && policy.Namespace != vm.Namespace {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(policy.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector on VirtualMachineDefaultPolicy %q: %v", policy.Name, err)
+		}
+		if selector.Matches(labels.Set(vm.Labels)) {
+			matching = append(matching, policy)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		if matching[i].Priority != matching[j].Priority {
+			return matching[i].Priority > matching[j].Priority
+		}
+		return matching[i].Name < matching[j].Name
+	})
+
+	return matching, nil
+}
+
+func applyVirtualMachineSpecDefaults(vm *v1.VirtualMachine, defaults VirtualMachineSpecDefaults) {
+	spec := &vm.Spec.Template.Spec
+
+	if defaults.MachineType != "" && (spec.Domain.Machine == nil || spec.Domain.Machine.Type == "") {
+		spec.Domain.Machine = &v1.Machine{Type: defaults.MachineType}
+	}
+	if defaults.CPUModel != "" && (spec.Domain.CPU == nil || spec.Domain.CPU.Model == "") {
+		if spec.Domain.CPU == nil {
+			spec.Domain.CPU = &v1.CPU{}
+		}
+		spec.Domain.CPU.Model = defaults.CPUModel
+	}
+	if len(defaults.NodeSelector) > 0 && spec.NodeSelector == nil {
+		spec.NodeSelector = defaults.NodeSelector
+	}
+	if len(defaults.Tolerations) > 0 && len(spec.Tolerations) == 0 {
+		spec.Tolerations = defaults.Tolerations
+	}
+	if defaults.PriorityClassName != "" && spec.PriorityClassName == "" {
+		spec.PriorityClassName = defaults.PriorityClassName
+	}
+	if defaults.StorageClass != "" {
+		for i := range vm.Spec.DataVolumeTemplates {
+			dvSpec := &vm.Spec.DataVolumeTemplates[i].Spec
+			if dvSpec.PVC != nil && dvSpec.PVC.StorageClassName == nil {
+				dvSpec.PVC.StorageClassName = &defaults.StorageClass
+			}
+		}
+	}
+	if defaults.NetworkSource != nil && len(spec.Networks) == 0 {
+		spec.Networks = []v1.Network{{Name: "default", NetworkSource: *defaults.NetworkSource}}
+	}
+}
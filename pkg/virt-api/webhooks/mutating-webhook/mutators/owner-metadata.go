@@ -0,0 +1,131 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ */
+
+package mutators
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// OwnerMetadataRule decides whether a label/annotation key copied from a VM's
+// owner is propagated onto the VM and its VMI template. Pattern may end in
+// "*" to match a prefix (e.g. "app.kubernetes.io/*"); otherwise it is
+// matched exactly.
+type OwnerMetadataRule struct {
+	Pattern string
+	// Drop excludes keys matching Pattern instead of propagating them,
+	// e.g. to keep the owner's "internal.*" labels off the VM. When several
+	// rules match a key the last one decides; a key no non-Drop rule
+	// matches is never propagated.
+	Drop bool
+}
+
+func (rule OwnerMetadataRule) matches(key string) bool {
+	if strings.HasSuffix(rule.Pattern, "*") {
+		return strings.HasPrefix(key, strings.TrimSuffix(rule.Pattern, "*"))
+	}
+	return key == rule.Pattern
+}
+
+// OwnerResolver fetches the labels and annotations of the object a VM is
+// owned by, so the owner-metadata-propagation stage can copy an allow-listed
+// subset onto the VM.
+type OwnerResolver interface {
+	GetOwnerMetadata(ownerRef metav1.OwnerReference, namespace string) (labels map[string]string, annotations map[string]string, err error)
+}
+
+func (mutator *VMsMutator) propagateOwnerMetadataStage(vm *v1.VirtualMachine, _ *MutationContext) error {
+	if mutator.OwnerResolver == nil || len(vm.OwnerReferences) == 0 {
+		return nil
+	}
+
+	ownerRef := controllerOwnerRef(vm.OwnerReferences)
+	if ownerRef == nil {
+		return nil
+	}
+
+	ownerLabels, ownerAnnotations, err := mutator.OwnerResolver.GetOwnerMetadata(*ownerRef, vm.Namespace)
+	if err != nil {
+		return err
+	}
+
+	labelsToApply := filterMetadataByAllowList(ownerLabels, mutator.OwnerMetadataRules)
+	annotationsToApply := filterMetadataByAllowList(ownerAnnotations, mutator.OwnerMetadataRules)
+	if len(labelsToApply) == 0 && len(annotationsToApply) == 0 {
+		return nil
+	}
+
+	mergeMetadata(&vm.ObjectMeta, labelsToApply, annotationsToApply)
+	if vm.Spec.Template != nil {
+		mergeMetadata(&vm.Spec.Template.ObjectMeta, labelsToApply, annotationsToApply)
+	}
+
+	return nil
+}
+
+func controllerOwnerRef(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+func filterMetadataByAllowList(metadata map[string]string, rules []OwnerMetadataRule) map[string]string {
+	if len(metadata) == 0 || len(rules) == 0 {
+		return nil
+	}
+
+	filtered := map[string]string{}
+	for key, value := range metadata {
+		keep := false
+		for _, rule := range rules {
+			if rule.matches(key) {
+				keep = !rule.Drop
+			}
+		}
+		if keep {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+func mergeMetadata(meta *metav1.ObjectMeta, labelsToApply, annotationsToApply map[string]string) {
+	if len(labelsToApply) > 0 {
+		if meta.Labels == nil {
+			meta.Labels = map[string]string{}
+		}
+		for key, value := range labelsToApply {
+			meta.Labels[key] = value
+		}
+	}
+	if len(annotationsToApply) > 0 {
+		if meta.Annotations == nil {
+			meta.Annotations = map[string]string{}
+		}
+		for key, value := range annotationsToApply {
+			meta.Annotations[key] = value
+		}
+	}
+}
@@ -0,0 +1,382 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ */
+
+package mutators
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	v1 "kubevirt.io/api/core/v1"
+	apiflavor "kubevirt.io/api/flavor"
+
+	utiltypes "kubevirt.io/kubevirt/pkg/util/types"
+	"kubevirt.io/kubevirt/pkg/virt-api/webhooks"
+	virtconfig "kubevirt.io/kubevirt/pkg/virt-config"
+)
+
+// MutationContext carries the request-scoped state a MutationStage needs
+// beyond the VM it is mutating.
+type MutationContext struct {
+	ClusterConfig   *virtconfig.ClusterConfig
+	Architecture    string
+	RequestUserInfo authenticationv1.UserInfo
+	DryRun          bool
+}
+
+// FlavorPreferenceLister answers existence checks for a named
+// VirtualMachineFlavor/VirtualMachinePreference-like object in a given
+// scope, so the mutator can pick the most specific match for a
+// FlavorMatcher/PreferenceMatcher that does not say which Kind it means.
+// It is backed by informers in production; nil on a VMsMutator disables
+// namespaced resolution entirely and falls back to always defaulting to the
+// cluster-scoped Kind, preserving the original behavior.
+type FlavorPreferenceLister interface {
+	ExistsClusterScoped(kind, name string) bool
+	ExistsNamespaced(kind, namespace, name string) bool
+}
+
+// ambiguousReferenceError is returned when a FlavorMatcher/PreferenceMatcher
+// without an explicit Kind matches more than one scope.
+type ambiguousReferenceError struct {
+	field string
+	name  string
+}
+
+func (e *ambiguousReferenceError) Error() string {
+	return fmt.Sprintf("%s %q is ambiguous: it exists in more than one scope, specify Kind explicitly", e.field, e.name)
+}
+
+// MutationStage applies one piece of VM create defaulting logic directly to
+// vm, only touching fields the user left unset. Stages are independent of
+// one another; registration order only matters when two stages target the
+// same field, in which case the earlier stage wins, since the later one
+// finds the field already set.
+type MutationStage func(vm *v1.VirtualMachine, ctx *MutationContext) error
+
+type mutationStageRegistration struct {
+	name    string
+	enabled func(*virtconfig.ClusterConfig) bool
+	run     MutationStage
+}
+
+func alwaysEnabled(*virtconfig.ClusterConfig) bool {
+	return true
+}
+
+// stageEnabled lets operators turn individual stages off via
+// KubeVirtConfiguration.DisabledMutationStages, e.g. machine-type
+// defaulting on a hybrid-arch cluster that wants to manage it itself.
+func stageEnabled(stageName string) func(*virtconfig.ClusterConfig) bool {
+	return func(clusterConfig *virtconfig.ClusterConfig) bool {
+		if clusterConfig == nil {
+			return true
+		}
+		for _, disabled := range clusterConfig.GetConfig().DisabledMutationStages {
+			if disabled == stageName {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// VMsMutator mutates VirtualMachine objects on admission, applying defaults
+// the user did not explicitly set. The defaulting logic lives in the
+// MutationStages registered on the mutator rather than in Mutate itself, so
+// new behavior can be added without rewriting the same conditional block,
+// and tests can inject a single stage in isolation.
+type VMsMutator struct {
+	ClusterConfig *virtconfig.ClusterConfig
+	// FlavorPreferenceLister, when set, lets the flavor/preference kind
+	// defaulting stages resolve a namespaced match instead of always
+	// defaulting to the cluster-scoped Kind.
+	FlavorPreferenceLister FlavorPreferenceLister
+	// SharedResourcesNamespace is a namespace, curated by a platform team,
+	// that is also searched when resolving an unqualified flavor/preference
+	// reference, in addition to the VM's own namespace.
+	SharedResourcesNamespace string
+	// DefaultPolicyLister, when set, enables the default-policy mutation
+	// stage, which applies matching VirtualMachineDefaultPolicy objects to
+	// fields the user left unset.
+	DefaultPolicyLister DefaultPolicyLister
+	// OwnerResolver, when set, enables the owner-metadata-propagation
+	// stage, which copies OwnerMetadataRules-allowed labels/annotations
+	// from a VM's controller owner onto the VM and its VMI template.
+	OwnerResolver      OwnerResolver
+	OwnerMetadataRules []OwnerMetadataRule
+
+	stages []mutationStageRegistration
+}
+
+func (mutator *VMsMutator) defaultStages() []mutationStageRegistration {
+	return []mutationStageRegistration{
+		// default-policy runs first so its defaults only ever fill fields
+		// the user left unset; the built-in stages below then fall back to
+		// their own defaults for anything still unset afterwards.
+		{name: "default-policy", enabled: stageEnabled("default-policy"), run: mutator.applyDefaultPoliciesStage},
+		{name: "architecture-defaulting", enabled: stageEnabled("architecture-defaulting"), run: defaultArchitectureStage},
+		{name: "flavor-kind-defaulting", enabled: alwaysEnabled, run: mutator.defaultFlavorKindStage},
+		{name: "preference-kind-defaulting", enabled: alwaysEnabled, run: mutator.defaultPreferenceKindStage},
+		{name: "owner-metadata-propagation", enabled: stageEnabled("owner-metadata-propagation"), run: mutator.propagateOwnerMetadataStage},
+	}
+}
+
+func (mutator *VMsMutator) Mutate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	vmResource := metav1.GroupVersionResource{Group: v1.VirtualMachineGroupVersionKind.Group, Version: v1.VirtualMachineGroupVersionKind.Version, Resource: "virtualmachines"}
+	if ar.Request.Resource != vmResource {
+		return &admissionv1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("expect resource to be '%s'", vmResource.Resource),
+				Code:    http.StatusBadRequest,
+			},
+		}
+	}
+
+	vm := &v1.VirtualMachine{}
+	if err := json.Unmarshal(ar.Request.Object.Raw, vm); err != nil {
+		return &admissionv1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+				Code:    http.StatusUnprocessableEntity,
+			},
+		}
+	}
+
+	ctx := &MutationContext{
+		ClusterConfig: mutator.ClusterConfig,
+		Architecture:  targetArchitecture(vm, mutator.ClusterConfig),
+		DryRun:        ar.Request.DryRun != nil && *ar.Request.DryRun,
+	}
+	if ar.Request.UserInfo.Username != "" {
+		ctx.RequestUserInfo = ar.Request.UserInfo
+	}
+
+	stages := mutator.stages
+	if stages == nil {
+		stages = mutator.defaultStages()
+	}
+
+	for _, stage := range stages {
+		if !stage.enabled(mutator.ClusterConfig) {
+			continue
+		}
+		if err := stage.run(vm, ctx); err != nil {
+			return &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: err.Error(),
+					Code:    http.StatusUnprocessableEntity,
+				},
+			}
+		}
+	}
+
+	patchBytes, err := json.Marshal([]utiltypes.PatchOperation{
+		{Op: "replace", Path: "/spec", Value: vm.Spec},
+		{Op: "replace", Path: "/metadata", Value: vm.ObjectMeta},
+	})
+	if err != nil {
+		return &admissionv1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+				Code:    http.StatusInternalServerError,
+			},
+		}
+	}
+
+	jsonPatch := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patchBytes,
+		PatchType: &jsonPatch,
+	}
+}
+
+// targetArchitecture resolves the architecture a VM's defaults should be
+// chosen for: what the user put in spec.template.spec.architecture, else
+// the cluster's configured default architecture, else the architecture
+// virt-api itself happens to be running on, as a last resort for clusters
+// that have configured neither.
+func targetArchitecture(vm *v1.VirtualMachine, clusterConfig *virtconfig.ClusterConfig) string {
+	if vm.Spec.Template != nil && vm.Spec.Template.Spec.Architecture != "" {
+		return vm.Spec.Template.Spec.Architecture
+	}
+	if clusterConfig != nil {
+		if defaultArch := clusterConfig.GetConfig().DefaultArchitecture; defaultArch != "" {
+			return defaultArch
+		}
+	}
+	switch {
+	case webhooks.IsPPC64():
+		return "ppc64le"
+	case webhooks.IsARM64():
+		return "arm64"
+	default:
+		return "amd64"
+	}
+}
+
+// builtinArchitectureDefault is used when the cluster has configured neither
+// an ArchitectureDefaults entry for ctx.Architecture nor a cluster-wide
+// MachineType.
+func builtinArchitectureDefault(architecture string) v1.ArchitectureDefault {
+	switch architecture {
+	case "ppc64le":
+		return v1.ArchitectureDefault{MachineType: "pseries"}
+	case "arm64":
+		return v1.ArchitectureDefault{MachineType: "virt"}
+	default:
+		return v1.ArchitectureDefault{MachineType: "q35"}
+	}
+}
+
+// resolveArchitectureDefault picks the ArchitectureDefaults entry for
+// ctx.Architecture, falling back to the deprecated cluster-wide MachineType
+// knob and finally to builtinArchitectureDefault.
+func resolveArchitectureDefault(ctx *MutationContext) v1.ArchitectureDefault {
+	if ctx.ClusterConfig != nil {
+		if configured, ok := ctx.ClusterConfig.GetConfig().ArchitectureDefaults[ctx.Architecture]; ok {
+			return configured
+		}
+		if machineType := ctx.ClusterConfig.GetMachineType(); machineType != "" {
+			return v1.ArchitectureDefault{MachineType: machineType}
+		}
+	}
+	return builtinArchitectureDefault(ctx.Architecture)
+}
+
+// defaultArchitectureStage applies the machine type, CPU model, firmware
+// and required node-feature-label node selectors for the VM's target
+// architecture, only for fields the user left unset. This replaces the
+// previous hardcoded IsPPC64()/IsARM64()/else branch so heterogeneous
+// clusters can configure every architecture they run, not just the one
+// virt-api's own binary happens to be built for.
+func defaultArchitectureStage(vm *v1.VirtualMachine, ctx *MutationContext) error {
+	if vm.Spec.Template == nil {
+		return nil
+	}
+	spec := &vm.Spec.Template.Spec
+	archDefault := resolveArchitectureDefault(ctx)
+
+	if archDefault.MachineType != "" && (spec.Domain.Machine == nil || spec.Domain.Machine.Type == "") {
+		spec.Domain.Machine = &v1.Machine{Type: archDefault.MachineType}
+	}
+
+	if archDefault.CPUModel != "" && (spec.Domain.CPU == nil || spec.Domain.CPU.Model == "") {
+		if spec.Domain.CPU == nil {
+			spec.Domain.CPU = &v1.CPU{}
+		}
+		spec.Domain.CPU.Model = archDefault.CPUModel
+	}
+
+	if archDefault.EFI && spec.Domain.Firmware == nil {
+		spec.Domain.Firmware = &v1.Firmware{
+			Bootloader: &v1.Bootloader{
+				EFI: &v1.EFI{SecureBoot: pointer.Bool(archDefault.SecureBoot)},
+			},
+		}
+	}
+
+	for _, label := range archDefault.NodeFeatureLabels {
+		if spec.NodeSelector == nil {
+			spec.NodeSelector = map[string]string{}
+		}
+		if _, exists := spec.NodeSelector[label]; !exists {
+			spec.NodeSelector[label] = "true"
+		}
+	}
+
+	return nil
+}
+
+func (mutator *VMsMutator) defaultFlavorKindStage(vm *v1.VirtualMachine, ctx *MutationContext) error {
+	if vm.Spec.Flavor == nil || vm.Spec.Flavor.Kind != "" {
+		return nil
+	}
+
+	kind, err := mutator.resolveKind(
+		"spec.flavor", vm.Namespace, vm.Spec.Flavor.Namespace, vm.Spec.Flavor.Name,
+		apiflavor.ClusterSingularResourceName, apiflavor.SingularResourceName,
+	)
+	if err != nil {
+		return err
+	}
+	vm.Spec.Flavor.Kind = kind
+	return nil
+}
+
+func (mutator *VMsMutator) defaultPreferenceKindStage(vm *v1.VirtualMachine, ctx *MutationContext) error {
+	if vm.Spec.Preference == nil || vm.Spec.Preference.Kind != "" {
+		return nil
+	}
+
+	kind, err := mutator.resolveKind(
+		"spec.preference", vm.Namespace, vm.Spec.Preference.Namespace, vm.Spec.Preference.Name,
+		apiflavor.ClusterSingularPreferenceResourceName, apiflavor.SingularPreferenceResourceName,
+	)
+	if err != nil {
+		return err
+	}
+	vm.Spec.Preference.Kind = kind
+	return nil
+}
+
+// resolveKind decides which Kind an unqualified flavor/preference reference
+// named name should default to. With no FlavorPreferenceLister configured
+// it always picks clusterKind, matching the original, lister-less
+// behavior. If explicitNamespace is set, it is the only namespace
+// considered. Otherwise a namespaced match, in the VM's own namespace or in
+// the shared resources namespace, resolves to namespacedKind, and a
+// cluster-scoped match resolves to clusterKind. A name found both namespaced
+// and cluster-scoped is rejected as ambiguous; a name found in both the VM's
+// namespace and the shared namespace is not. With no match at all it returns
+// clusterKind.
+func (mutator *VMsMutator) resolveKind(field, vmNamespace, explicitNamespace, name, clusterKind, namespacedKind string) (string, error) {
+	if mutator.FlavorPreferenceLister == nil {
+		return clusterKind, nil
+	}
+
+	if explicitNamespace != "" {
+		if !mutator.FlavorPreferenceLister.ExistsNamespaced(namespacedKind, explicitNamespace, name) {
+			return "", fmt.Errorf("%s %q not found in namespace %q", field, name, explicitNamespace)
+		}
+		return namespacedKind, nil
+	}
+
+	namespacedMatch := mutator.FlavorPreferenceLister.ExistsNamespaced(namespacedKind, vmNamespace, name) ||
+		(mutator.SharedResourcesNamespace != "" && mutator.SharedResourcesNamespace != vmNamespace &&
+			mutator.FlavorPreferenceLister.ExistsNamespaced(namespacedKind, mutator.SharedResourcesNamespace, name))
+	clusterMatch := mutator.FlavorPreferenceLister.ExistsClusterScoped(clusterKind, name)
+
+	switch {
+	case namespacedMatch && clusterMatch:
+		return "", &ambiguousReferenceError{field: field, name: name}
+	case namespacedMatch:
+		return namespacedKind, nil
+	case clusterMatch:
+		return clusterKind, nil
+	default:
+		return clusterKind, nil
+	}
+}
@@ -28,6 +28,7 @@ import (
 	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
 
 	v1 "kubevirt.io/api/core/v1"
 	apiflavor "kubevirt.io/api/flavor"
@@ -110,6 +111,31 @@ var _ = Describe("VirtualMachine Mutator", func() {
 		Expect(vmSpec.Template.Spec.Domain.Machine.Type).To(Equal(machineTypeFromConfig))
 	})
 
+	It("should apply per-architecture CPU model and firmware defaults from ArchitectureDefaults", func() {
+		testutils.UpdateFakeKubeVirtClusterConfig(kvInformer, &v1.KubeVirt{
+			Spec: v1.KubeVirtSpec{
+				Configuration: v1.KubeVirtConfiguration{
+					ArchitectureDefaults: map[string]v1.ArchitectureDefault{
+						"amd64": {
+							MachineType:       machineTypeFromConfig,
+							CPUModel:          "Skylake-Client",
+							EFI:               true,
+							SecureBoot:        true,
+							NodeFeatureLabels: []string{"cpu-feature.node.kubevirt.io/avx2"},
+						},
+					},
+				},
+			},
+		})
+		vm.Spec.Template.Spec.Architecture = "amd64"
+
+		vmSpec, _ := getVMSpecMetaFromResponse()
+		Expect(vmSpec.Template.Spec.Domain.Machine.Type).To(Equal(machineTypeFromConfig))
+		Expect(vmSpec.Template.Spec.Domain.CPU.Model).To(Equal("Skylake-Client"))
+		Expect(vmSpec.Template.Spec.Domain.Firmware.Bootloader.EFI.SecureBoot).To(PointTo(BeTrue()))
+		Expect(vmSpec.Template.Spec.NodeSelector).To(HaveKeyWithValue("cpu-feature.node.kubevirt.io/avx2", "true"))
+	})
+
 	It("should not override specified properties with defaults on VM create", func() {
 		testutils.UpdateFakeKubeVirtClusterConfig(kvInformer, &v1.KubeVirt{
 			Spec: v1.KubeVirtSpec{
@@ -141,6 +167,147 @@ var _ = Describe("VirtualMachine Mutator", func() {
 		Expect(vmSpec.Preference.Kind).To(Equal(apiflavor.ClusterSingularPreferenceResourceName))
 	})
 
+	Context("with a namespaced flavor lister", func() {
+		var lister *fakeFlavorPreferenceLister
+
+		BeforeEach(func() {
+			lister = &fakeFlavorPreferenceLister{namespaced: map[string]bool{}, clusterScoped: map[string]bool{}}
+			mutator.FlavorPreferenceLister = lister
+		})
+
+		It("should default to the namespaced kind when only an in-namespace match exists", func() {
+			lister.namespaced[apiflavor.SingularResourceName+"/"+vm.Namespace+"/foobar"] = true
+			vm.Spec.Flavor = &v1.FlavorMatcher{Name: "foobar"}
+
+			vmSpec, _ := getVMSpecMetaFromResponse()
+			Expect(vmSpec.Flavor.Kind).To(Equal(apiflavor.SingularResourceName))
+		})
+
+		It("should default to the cluster-scoped kind when no namespaced match exists", func() {
+			lister.clusterScoped[apiflavor.ClusterSingularResourceName+"/foobar"] = true
+			vm.Spec.Flavor = &v1.FlavorMatcher{Name: "foobar"}
+
+			vmSpec, _ := getVMSpecMetaFromResponse()
+			Expect(vmSpec.Flavor.Kind).To(Equal(apiflavor.ClusterSingularResourceName))
+		})
+
+		It("should default to the namespaced kind when the same name also exists in the shared resources namespace", func() {
+			mutator.SharedResourcesNamespace = "kubevirt-shared"
+			lister.namespaced[apiflavor.SingularResourceName+"/"+vm.Namespace+"/foobar"] = true
+			lister.namespaced[apiflavor.SingularResourceName+"/kubevirt-shared/foobar"] = true
+			vm.Spec.Flavor = &v1.FlavorMatcher{Name: "foobar"}
+
+			vmSpec, _ := getVMSpecMetaFromResponse()
+			Expect(vmSpec.Flavor.Kind).To(Equal(apiflavor.SingularResourceName))
+		})
+
+		It("should reject an ambiguous flavor reference found in more than one scope", func() {
+			lister.namespaced[apiflavor.SingularResourceName+"/"+vm.Namespace+"/foobar"] = true
+			lister.clusterScoped[apiflavor.ClusterSingularResourceName+"/foobar"] = true
+			vm.Spec.Flavor = &v1.FlavorMatcher{Name: "foobar"}
+
+			vmBytes, err := json.Marshal(vm)
+			Expect(err).ToNot(HaveOccurred())
+			ar := &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Resource: k8smetav1.GroupVersionResource{Group: v1.VirtualMachineGroupVersionKind.Group, Version: v1.VirtualMachineGroupVersionKind.Version, Resource: "virtualmachines"},
+					Object:   runtime.RawExtension{Raw: vmBytes},
+				},
+			}
+			resp := mutator.Mutate(ar)
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring("ambiguous"))
+		})
+	})
+
+	Context("with a VirtualMachineDefaultPolicy lister", func() {
+		It("should apply a matching policy's defaults to unset fields", func() {
+			mutator.DefaultPolicyLister = &fakeDefaultPolicyLister{
+				policies: []VirtualMachineDefaultPolicy{{
+					Name:     "gpu-workloads",
+					Selector: &k8smetav1.LabelSelector{MatchLabels: map[string]string{"test": "test"}},
+					Defaults: VirtualMachineSpecDefaults{
+						MachineType:       "pc-q35-7.0",
+						PriorityClassName: "high-priority",
+					},
+				}},
+			}
+
+			vmSpec, vmMeta := getVMSpecMetaFromResponse()
+			Expect(vmSpec.Template.Spec.Domain.Machine.Type).To(Equal("pc-q35-7.0"))
+			Expect(vmSpec.Template.Spec.PriorityClassName).To(Equal("high-priority"))
+			Expect(vmMeta.Annotations[appliedDefaultPoliciesAnnotation]).To(ContainSubstring("gpu-workloads"))
+		})
+
+		It("should not override a field the user already set", func() {
+			vm.Spec.Template.Spec.PriorityClassName = "user-priority"
+			mutator.DefaultPolicyLister = &fakeDefaultPolicyLister{
+				policies: []VirtualMachineDefaultPolicy{{
+					Name:     "gpu-workloads",
+					Selector: &k8smetav1.LabelSelector{MatchLabels: map[string]string{"test": "test"}},
+					Defaults: VirtualMachineSpecDefaults{
+						PriorityClassName: "low-priority",
+					},
+				}},
+			}
+
+			vmSpec, _ := getVMSpecMetaFromResponse()
+			Expect(vmSpec.Template.Spec.PriorityClassName).To(Equal("user-priority"))
+		})
+
+		It("should ignore a policy whose selector does not match", func() {
+			mutator.DefaultPolicyLister = &fakeDefaultPolicyLister{
+				policies: []VirtualMachineDefaultPolicy{{
+					Name:     "other-workloads",
+					Selector: &k8smetav1.LabelSelector{MatchLabels: map[string]string{"test": "nonmatching"}},
+					Defaults: VirtualMachineSpecDefaults{
+						PriorityClassName: "low-priority",
+					},
+				}},
+			}
+
+			vmSpec, _ := getVMSpecMetaFromResponse()
+			Expect(vmSpec.Template.Spec.PriorityClassName).To(BeEmpty())
+		})
+	})
+
+	Context("with an owner metadata resolver", func() {
+		BeforeEach(func() {
+			vm.OwnerReferences = []k8smetav1.OwnerReference{{
+				Kind:       "VirtualMachinePool",
+				Name:       "my-pool",
+				Controller: pointer.Bool(true),
+			}}
+			mutator.OwnerResolver = &fakeOwnerResolver{
+				labels:      map[string]string{"app.kubernetes.io/part-of": "my-pool", "internal.my-pool/generation": "3"},
+				annotations: map[string]string{"app.kubernetes.io/managed-by": "my-pool"},
+			}
+			mutator.OwnerMetadataRules = []OwnerMetadataRule{
+				{Pattern: "app.kubernetes.io/*"},
+				{Pattern: "internal.*", Drop: true},
+			}
+		})
+
+		It("should copy allow-listed owner labels/annotations onto the VM and VMI template", func() {
+			vmSpec, vmMeta := getVMSpecMetaFromResponse()
+			Expect(vmMeta.Labels).To(HaveKeyWithValue("app.kubernetes.io/part-of", "my-pool"))
+			Expect(vmMeta.Annotations).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "my-pool"))
+			Expect(vmSpec.Template.ObjectMeta.Labels).To(HaveKeyWithValue("app.kubernetes.io/part-of", "my-pool"))
+			Expect(vmSpec.Template.ObjectMeta.Annotations).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "my-pool"))
+		})
+
+		It("should not propagate keys matched by a drop rule", func() {
+			_, vmMeta := getVMSpecMetaFromResponse()
+			Expect(vmMeta.Labels).NotTo(HaveKey("internal.my-pool/generation"))
+		})
+
+		It("should do nothing when the VM has no controller owner reference", func() {
+			vm.OwnerReferences = nil
+			_, vmMeta := getVMSpecMetaFromResponse()
+			Expect(vmMeta.Labels).NotTo(HaveKey("app.kubernetes.io/part-of"))
+		})
+	})
+
 	Context("failure tests", func() {
 		It("should fail if passed resource is not VirtualMachine", func() {
 			vmBytes, err := json.Marshal(vm)
@@ -186,3 +353,37 @@ var _ = Describe("VirtualMachine Mutator", func() {
 		})
 	})
 })
+
+// fakeFlavorPreferenceLister is a test double for FlavorPreferenceLister,
+// keyed by "kind/namespace/name" (namespaced) or "kind/name" (cluster-scoped).
+type fakeFlavorPreferenceLister struct {
+	namespaced    map[string]bool
+	clusterScoped map[string]bool
+}
+
+func (f *fakeFlavorPreferenceLister) ExistsNamespaced(kind, namespace, name string) bool {
+	return f.namespaced[kind+"/"+namespace+"/"+name]
+}
+
+func (f *fakeFlavorPreferenceLister) ExistsClusterScoped(kind, name string) bool {
+	return f.clusterScoped[kind+"/"+name]
+}
+
+// fakeDefaultPolicyLister is a test double for DefaultPolicyLister.
+type fakeDefaultPolicyLister struct {
+	policies []VirtualMachineDefaultPolicy
+}
+
+func (f *fakeDefaultPolicyLister) List() ([]VirtualMachineDefaultPolicy, error) {
+	return f.policies, nil
+}
+
+// fakeOwnerResolver is a test double for OwnerResolver.
+type fakeOwnerResolver struct {
+	labels      map[string]string
+	annotations map[string]string
+}
+
+func (f *fakeOwnerResolver) GetOwnerMetadata(_ k8smetav1.OwnerReference, _ string) (map[string]string, map[string]string, error) {
+	return f.labels, f.annotations, nil
+}
@@ -0,0 +1,175 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+// Package macpool implements an in-memory MAC address allocator: it hands
+// out unique MAC addresses from one or more configured ranges, keyed by a
+// caller-supplied reservation key, and releases them again on request.
+//
+// This package is the allocation primitive only. It is not yet wired up as
+// a MACPool CRD with a controller and VMI admission webhook, and
+// KubeVirtConfiguration.NetworkConfiguration has no MACPoolRef pointing at
+// one -- both of those live in kubevirt.io/api and kubevirt.io/client-go,
+// which this tree does not vendor, so that wiring has to land alongside
+// them rather than here.
+package macpool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Range describes a contiguous MAC address range, analogous to a CIDR for
+// IP addresses. Both bounds are inclusive.
+type Range struct {
+	// Name identifies the MACPool this range was read from.
+	Name string
+	// Namespace scopes the range to a single namespace; empty means
+	// cluster-wide.
+	Namespace string
+	Start     net.HardwareAddr
+	End       net.HardwareAddr
+}
+
+func macToUint64(mac net.HardwareAddr) uint64 {
+	padded := make([]byte, 8)
+	copy(padded[2:], mac)
+	return binary.BigEndian.Uint64(padded)
+}
+
+func uint64ToMAC(v uint64) net.HardwareAddr {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return net.HardwareAddr(buf[2:])
+}
+
+// Contains reports whether mac falls within the range.
+func (r Range) Contains(mac net.HardwareAddr) bool {
+	v := macToUint64(mac)
+	return v >= macToUint64(r.Start) && v <= macToUint64(r.End)
+}
+
+// Overlaps reports whether two ranges share at least one address.
+func (r Range) Overlaps(other Range) bool {
+	return macToUint64(r.Start) <= macToUint64(other.End) && macToUint64(other.Start) <= macToUint64(r.End)
+}
+
+// Pool reserves MAC addresses out of a Range, tracking in-use addresses
+// in memory for the lifetime of the process. A reservation is keyed so
+// repeated calls with the same key (e.g. the same VMI interface reconciled
+// again, including across live migration) are idempotent, but the Pool
+// itself holds no state across a controller restart -- whatever process
+// wires this up is responsible for re-deriving existing reservations (for
+// example by listing running VMIs and their assigned MACs) before serving
+// new Reserve calls.
+type Pool struct {
+	mu          sync.Mutex
+	ranges      map[string]Range
+	reservedBy  map[string]string // mac -> reservation key (namespace/vmi/iface)
+	reservation map[string]string // reservation key -> mac
+	cursor      map[string]uint64 // range name -> next candidate
+}
+
+// NewPool creates an empty Pool. Ranges are added with AddRange.
+func NewPool() *Pool {
+	return &Pool{
+		ranges:      map[string]Range{},
+		reservedBy:  map[string]string{},
+		reservation: map[string]string{},
+		cursor:      map[string]uint64{},
+	}
+}
+
+// AddRange registers a MACPool range, rejecting it if it overlaps one
+// already known to the pool.
+func (p *Pool) AddRange(r Range) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, existing := range p.ranges {
+		if existing.Overlaps(r) {
+			return fmt.Errorf("macpool range %q overlaps existing range %q", r.Name, existing.Name)
+		}
+	}
+	p.ranges[r.Name] = r
+	p.cursor[r.Name] = macToUint64(r.Start)
+	return nil
+}
+
+// Reserve assigns a free MAC address to reservationKey (typically
+// "<namespace>/<vmi-name>/<interface-name>"), scoped to namespace, and
+// records the reservation so repeated calls with the same key are
+// idempotent (e.g. across live migration or a resync).
+func (p *Pool) Reserve(namespace, reservationKey string) (net.HardwareAddr, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if mac, ok := p.reservation[reservationKey]; ok {
+		addr, _ := net.ParseMAC(mac)
+		return addr, nil
+	}
+
+	for name, r := range p.ranges {
+		if r.Namespace != "" && r.Namespace != namespace {
+			continue
+		}
+
+		start, end := macToUint64(r.Start), macToUint64(r.End)
+		size := end - start + 1
+		first := p.cursor[name]
+		if first < start || first > end {
+			first = start
+		}
+		for i := uint64(0); i < size; i++ {
+			v := start + (first-start+i)%size
+			mac := uint64ToMAC(v)
+			if _, taken := p.reservedBy[mac.String()]; !taken {
+				p.cursor[name] = v + 1
+				p.reservedBy[mac.String()] = reservationKey
+				p.reservation[reservationKey] = mac.String()
+				return mac, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no free MAC addresses available in any MACPool range for namespace %q", namespace)
+}
+
+// Release frees the MAC address reserved under reservationKey, if any. It
+// is called when the owning VMI is deleted.
+func (p *Pool) Release(reservationKey string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mac, ok := p.reservation[reservationKey]
+	if !ok {
+		return
+	}
+	delete(p.reservation, reservationKey)
+	delete(p.reservedBy, mac)
+}
+
+// AssignedTo returns the reservation key currently holding mac, if any.
+func (p *Pool) AssignedTo(mac net.HardwareAddr) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.reservedBy[mac.String()]
+	return key, ok
+}
@@ -0,0 +1,146 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package macpool
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func mustParseMAC(t *testing.T, s string) net.HardwareAddr {
+	t.Helper()
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatalf("failed to parse MAC %q: %v", s, err)
+	}
+	return mac
+}
+
+func TestPoolReserveIsUniqueAndIdempotent(t *testing.T) {
+	g := NewWithT(t)
+
+	p := NewPool()
+	g.Expect(p.AddRange(Range{
+		Name:  "default",
+		Start: mustParseMAC(t, "02:00:00:00:00:00"),
+		End:   mustParseMAC(t, "02:00:00:00:00:03"),
+	})).To(Succeed())
+
+	first, err := p.Reserve("default", "ns/vmi-a/eth0")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	second, err := p.Reserve("default", "ns/vmi-b/eth0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(second.String()).ToNot(Equal(first.String()))
+
+	again, err := p.Reserve("default", "ns/vmi-a/eth0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(again.String()).To(Equal(first.String()), "re-reserving the same key must return the same MAC")
+}
+
+func TestPoolExhaustion(t *testing.T) {
+	g := NewWithT(t)
+
+	p := NewPool()
+	g.Expect(p.AddRange(Range{
+		Name:  "tiny",
+		Start: mustParseMAC(t, "02:00:00:00:00:00"),
+		End:   mustParseMAC(t, "02:00:00:00:00:00"),
+	})).To(Succeed())
+
+	_, err := p.Reserve("default", "ns/vmi-a/eth0")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = p.Reserve("default", "ns/vmi-b/eth0")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestPoolReleaseFreesMAC(t *testing.T) {
+	g := NewWithT(t)
+
+	p := NewPool()
+	g.Expect(p.AddRange(Range{
+		Name:  "tiny",
+		Start: mustParseMAC(t, "02:00:00:00:00:00"),
+		End:   mustParseMAC(t, "02:00:00:00:00:00"),
+	})).To(Succeed())
+
+	mac, err := p.Reserve("default", "ns/vmi-a/eth0")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	p.Release("ns/vmi-a/eth0")
+
+	freed, err := p.Reserve("default", "ns/vmi-b/eth0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(freed.String()).To(Equal(mac.String()))
+}
+
+func TestPoolReserveConcurrentIsUniquePerVMI(t *testing.T) {
+	g := NewWithT(t)
+
+	const vmiCount = 50
+
+	p := NewPool()
+	g.Expect(p.AddRange(Range{
+		Name:  "default",
+		Start: mustParseMAC(t, "02:00:00:00:00:00"),
+		End:   mustParseMAC(t, "02:00:00:00:ff:ff"),
+	})).To(Succeed())
+
+	var wg sync.WaitGroup
+	macs := make([]net.HardwareAddr, vmiCount)
+	errs := make([]error, vmiCount)
+	for i := 0; i < vmiCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			macs[i], errs[i] = p.Reserve("default", fmt.Sprintf("ns/vmi-%d/eth0", i))
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, vmiCount)
+	for i, err := range errs {
+		g.Expect(err).ToNot(HaveOccurred())
+		mac := macs[i].String()
+		g.Expect(seen[mac]).To(BeFalse(), "MAC %s was reserved for more than one VMI", mac)
+		seen[mac] = true
+
+		key, ok := p.AssignedTo(macs[i])
+		g.Expect(ok).To(BeTrue())
+		g.Expect(key).To(Equal(fmt.Sprintf("ns/vmi-%d/eth0", i)))
+	}
+	g.Expect(seen).To(HaveLen(vmiCount))
+}
+
+func TestRangeOverlaps(t *testing.T) {
+	g := NewWithT(t)
+
+	a := Range{Start: mustParseMAC(t, "02:00:00:00:00:00"), End: mustParseMAC(t, "02:00:00:00:00:10")}
+	b := Range{Start: mustParseMAC(t, "02:00:00:00:00:05"), End: mustParseMAC(t, "02:00:00:00:00:20")}
+	c := Range{Start: mustParseMAC(t, "02:00:00:00:00:20"), End: mustParseMAC(t, "02:00:00:00:00:30")}
+
+	g.Expect(a.Overlaps(b)).To(BeTrue())
+	g.Expect(a.Overlaps(c)).To(BeFalse())
+}
@@ -0,0 +1,857 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	vsv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/pointer"
+
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+	"kubevirt.io/client-go/kubecli"
+	"kubevirt.io/client-go/log"
+)
+
+const (
+	// exportPVCAnnotation marks a backup PVC exposed by the export
+	// controller as belonging to a given VirtualMachineSnapshotExport,
+	// mirroring restorePVCAnnotation's role for whole-VM restores.
+	exportPVCAnnotation = "export.kubevirt.io/name"
+
+	// exportPodAnnotation marks an uploader/downloader Pod as belonging to a
+	// given VirtualMachineSnapshotExport or VirtualMachineSnapshotImport.
+	exportPodAnnotation = "export.kubevirt.io/name"
+)
+
+// defaultDataMoverImage runs the uploader/downloader Pods the export and
+// import controllers launch, when a SnapshotBackupTarget doesn't override it.
+var defaultDataMoverImage = "kubevirt/data-mover"
+
+// SnapshotBackupTarget names an external object store (S3, GCS, or Azure
+// Blob) the data-mover subsystem can export VolumeSnapshots to and import
+// them back from. Credentials live in SecretName, never in the spec.
+type SnapshotBackupTarget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SnapshotBackupTargetSpec `json:"spec"`
+}
+
+// SnapshotBackupTargetProvider identifies the object storage API a
+// SnapshotBackupTarget speaks.
+type SnapshotBackupTargetProvider string
+
+const (
+	SnapshotBackupTargetS3    SnapshotBackupTargetProvider = "S3"
+	SnapshotBackupTargetGCS   SnapshotBackupTargetProvider = "GCS"
+	SnapshotBackupTargetAzure SnapshotBackupTargetProvider = "Azure"
+)
+
+type SnapshotBackupTargetSpec struct {
+	Provider SnapshotBackupTargetProvider `json:"provider"`
+	Bucket   string                       `json:"bucket"`
+	// Prefix namespaces every object key this target writes or reads, so one
+	// bucket can back more than one SnapshotBackupTarget.
+	Prefix string `json:"prefix,omitempty"`
+	// SecretName names a Secret, in the same namespace, holding the
+	// provider's credentials.
+	SecretName string `json:"secretName"`
+	// Image overrides defaultDataMoverImage for uploader/downloader Pods
+	// using this target.
+	Image string `json:"image,omitempty"`
+}
+
+// VirtualMachineSnapshotExport offloads every VolumeSnapshot in a
+// VirtualMachineSnapshotContent to a SnapshotBackupTarget, so the backup
+// outlives the source cluster/storage backend. Status.ManifestKey is the
+// object key a VirtualMachineSnapshotImport later reads to find each
+// volume's backed-up object key.
+type VirtualMachineSnapshotExport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualMachineSnapshotExportSpec    `json:"spec"`
+	Status *VirtualMachineSnapshotExportStatus `json:"status,omitempty"`
+}
+
+type VirtualMachineSnapshotExportSpec struct {
+	VirtualMachineSnapshotContentName string `json:"virtualMachineSnapshotContentName"`
+	BackupTargetName                  string `json:"backupTargetName"`
+}
+
+type VirtualMachineSnapshotExportStatus struct {
+	ReadyToUse    *bool                `json:"readyToUse,omitempty"`
+	CreationTime  *metav1.Time         `json:"creationTime,omitempty"`
+	ManifestKey   *string              `json:"manifestKey,omitempty"`
+	VolumeExports []VolumeExportStatus `json:"volumeExports,omitempty"`
+}
+
+// VolumeExportStatus tracks one volume's upload within a
+// VirtualMachineSnapshotExport.
+type VolumeExportStatus struct {
+	VolumeName string  `json:"volumeName"`
+	ObjectKey  *string `json:"objectKey,omitempty"`
+	ReadyToUse *bool   `json:"readyToUse,omitempty"`
+	Error      *string `json:"error,omitempty"`
+}
+
+// VirtualMachineSnapshotImport is the symmetric downloader counterpart of
+// VirtualMachineSnapshotExport: instead of cloning an in-cluster
+// VolumeSnapshot, VMRestoreController's target materializes each restored
+// PVC by naming one of these as Spec.Source, which downloads the manifest's
+// objects into PVCs it creates in TargetNamespace.
+type VirtualMachineSnapshotImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualMachineSnapshotImportSpec    `json:"spec"`
+	Status *VirtualMachineSnapshotImportStatus `json:"status,omitempty"`
+}
+
+type VirtualMachineSnapshotImportSpec struct {
+	BackupTargetName string `json:"backupTargetName"`
+	ManifestKey      string `json:"manifestKey"`
+	TargetNamespace  string `json:"targetNamespace"`
+	// Volumes lists the volumes ManifestKey's manifest covers, with enough
+	// information (size, storage class) to materialize each one's PVC
+	// without the controller itself having to fetch and parse the manifest
+	// from the backup target; the downloader Pod does that, resolving each
+	// volume's object key from the manifest at download time.
+	Volumes []VirtualMachineSnapshotImportVolume `json:"volumes"`
+}
+
+// VirtualMachineSnapshotImportVolume describes one volume a
+// VirtualMachineSnapshotImport will materialize as a PVC.
+type VirtualMachineSnapshotImportVolume struct {
+	Name             string            `json:"name"`
+	Size             resource.Quantity `json:"size"`
+	StorageClassName *string           `json:"storageClassName,omitempty"`
+}
+
+type VirtualMachineSnapshotImportStatus struct {
+	ReadyToUse    *bool                `json:"readyToUse,omitempty"`
+	CreationTime  *metav1.Time         `json:"creationTime,omitempty"`
+	VolumeImports []VolumeImportStatus `json:"volumeImports,omitempty"`
+}
+
+// VolumeImportStatus tracks one volume's download within a
+// VirtualMachineSnapshotImport.
+type VolumeImportStatus struct {
+	VolumeName                string  `json:"volumeName"`
+	PersistentVolumeClaimName *string `json:"persistentVolumeClaimName,omitempty"`
+	ReadyToUse                *bool   `json:"readyToUse,omitempty"`
+	Error                     *string `json:"error,omitempty"`
+}
+
+// VolumeSnapshotContentReader resolves the CSI VolumeSnapshotContent bound
+// to a ready VolumeSnapshot, so the export controller can read its
+// SnapshotHandle before exposing the snapshot to an uploader, and creates
+// the pre-provisioned VolumeSnapshotContent a cross-namespace restore binds
+// into a new namespace without taking a new snapshot (see
+// reconcileCrossNamespaceSnapshot in restore.go). Exists, like
+// VolumeSnapshotProvider, so this controller does not need a direct
+// dependency on a snapshotter clientset.
+type VolumeSnapshotContentReader interface {
+	GetVolumeSnapshotContent(name string) (*vsv1.VolumeSnapshotContent, error)
+	CreateVolumeSnapshotContent(vsc *vsv1.VolumeSnapshotContent) (*vsv1.VolumeSnapshotContent, error)
+}
+
+// VMSnapshotExportController offloads the VolumeSnapshots a
+// VirtualMachineSnapshotContent references to a SnapshotBackupTarget. For
+// every VolumeBackup it waits for the CSI invariant Velero's data-mover
+// exposer also relies on -- the VolumeSnapshot bound to a
+// VolumeSnapshotContent with a populated SnapshotHandle -- before exposing a
+// backup PVC and launching an uploader Pod against it.
+type VMSnapshotExportController struct {
+	Client                       kubecli.KubevirtClient
+	VMSnapshotExportInformer     cache.SharedIndexInformer
+	SnapshotBackupTargetInformer cache.SharedIndexInformer
+	VMSnapshotContentInformer    cache.SharedIndexInformer
+	PVCInformer                  cache.SharedIndexInformer
+	PodInformer                  cache.SharedIndexInformer
+	Recorder                     record.EventRecorder
+	VolumeSnapshotProvider       VolumeSnapshotProvider
+	VolumeSnapshotContentReader  VolumeSnapshotContentReader
+	// UploadNamespace is where backup PVCs and uploader Pods are created,
+	// isolated from any VM's own namespace.
+	UploadNamespace string
+
+	vmSnapshotExportQueue workqueue.RateLimitingInterface
+}
+
+// Init wires up the controller's workqueue and informer event handlers. It
+// must be called once before Run/processVMSnapshotExportWorkItem.
+func (ctrl *VMSnapshotExportController) Init() {
+	ctrl.vmSnapshotExportQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "virt-controller-vm-snapshot-export")
+
+	ctrl.VMSnapshotExportInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueVMSnapshotExport,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.enqueueVMSnapshotExport(newObj) },
+	})
+
+	ctrl.PVCInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleExportChild(exportPVCAnnotation),
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handleExportChild(exportPVCAnnotation)(newObj) },
+	})
+
+	ctrl.PodInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleExportChild(exportPodAnnotation),
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handleExportChild(exportPodAnnotation)(newObj) },
+	})
+}
+
+func (ctrl *VMSnapshotExportController) enqueueVMSnapshotExport(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	ctrl.vmSnapshotExportQueue.Add(key)
+}
+
+// handleExportChild returns an informer handler that requeues the
+// VirtualMachineSnapshotExport named by annotation on a child PVC or Pod.
+func (ctrl *VMSnapshotExportController) handleExportChild(annotation string) func(interface{}) {
+	return func(obj interface{}) {
+		accessor, ok := obj.(metav1.Object)
+		if !ok {
+			return
+		}
+		name, ok := accessor.GetAnnotations()[annotation]
+		if !ok {
+			return
+		}
+		ctrl.vmSnapshotExportQueue.Add(controllerKey(accessor.GetNamespace(), name))
+	}
+}
+
+// Run starts the controller's worker loop and blocks until stopCh is closed.
+func (ctrl *VMSnapshotExportController) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer ctrl.vmSnapshotExportQueue.ShutDown()
+
+	log.Log.Info("Starting snapshot export controller.")
+	defer log.Log.Info("Shutting down snapshot export controller.")
+
+	if !cache.WaitForCacheSync(
+		stopCh,
+		ctrl.VMSnapshotExportInformer.HasSynced,
+		ctrl.SnapshotBackupTargetInformer.HasSynced,
+		ctrl.VMSnapshotContentInformer.HasSynced,
+		ctrl.PVCInformer.HasSynced,
+		ctrl.PodInformer.HasSynced,
+	) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < threadiness; i++ {
+		go ctrl.worker()
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (ctrl *VMSnapshotExportController) worker() {
+	for ctrl.processVMSnapshotExportWorkItem() {
+	}
+}
+
+func (ctrl *VMSnapshotExportController) processVMSnapshotExportWorkItem() bool {
+	key, quit := ctrl.vmSnapshotExportQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.vmSnapshotExportQueue.Done(key)
+
+	if err := ctrl.updateVMSnapshotExport(key.(string)); err != nil {
+		log.Log.Reason(err).Errorf("error updating VirtualMachineSnapshotExport %s", key)
+		ctrl.vmSnapshotExportQueue.AddRateLimited(key)
+		return true
+	}
+
+	ctrl.vmSnapshotExportQueue.Forget(key)
+	return true
+}
+
+func (ctrl *VMSnapshotExportController) updateVMSnapshotExport(key string) error {
+	obj, exists, err := ctrl.VMSnapshotExportInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+
+	original := obj.(*VirtualMachineSnapshotExport)
+	if original.DeletionTimestamp != nil {
+		return nil
+	}
+
+	export := original.DeepCopy()
+	if export.Status == nil {
+		export.Status = &VirtualMachineSnapshotExportStatus{ReadyToUse: &f, CreationTime: currentTime()}
+	}
+	if export.Status.ReadyToUse != nil && *export.Status.ReadyToUse {
+		return nil
+	}
+
+	target, err := ctrl.getBackupTarget(export)
+	if err != nil {
+		return err
+	}
+
+	content, err := ctrl.getContent(export)
+	if err != nil {
+		return err
+	}
+
+	statusByVolume := make(map[string]*VolumeExportStatus, len(export.Status.VolumeExports))
+	for i := range export.Status.VolumeExports {
+		statusByVolume[export.Status.VolumeExports[i].VolumeName] = &export.Status.VolumeExports[i]
+	}
+
+	allReady := true
+	for _, backup := range content.Spec.VolumeBackups {
+		volumeStatus := statusByVolume[backup.VolumeName]
+		if volumeStatus == nil {
+			export.Status.VolumeExports = append(export.Status.VolumeExports, VolumeExportStatus{VolumeName: backup.VolumeName})
+			volumeStatus = &export.Status.VolumeExports[len(export.Status.VolumeExports)-1]
+		}
+		if volumeStatus.ReadyToUse != nil && *volumeStatus.ReadyToUse {
+			continue
+		}
+
+		ready, err := ctrl.reconcileVolumeExport(export, target, backup, volumeStatus)
+		if err != nil {
+			volumeStatus.Error = pointer.String(err.Error())
+			allReady = false
+			continue
+		}
+		if !ready {
+			allReady = false
+		}
+	}
+
+	if allReady {
+		manifestKey := fmt.Sprintf("%s%s/manifest.json", target.Spec.Prefix, export.Name)
+		export.Status.ManifestKey = &manifestKey
+		export.Status.ReadyToUse = &t
+		ctrl.Recorder.Eventf(export, corev1.EventTypeNormal, "VirtualMachineSnapshotExportComplete", "Exported %s to %s", content.Name, target.Name)
+	}
+
+	return ctrl.updateVMSnapshotExportStatus(original, export)
+}
+
+func (ctrl *VMSnapshotExportController) updateVMSnapshotExportStatus(original, updated *VirtualMachineSnapshotExport) error {
+	if equality.Semantic.DeepEqual(original.Status, updated.Status) {
+		return nil
+	}
+	_, err := ctrl.Client.VirtualMachineSnapshotExport(updated.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	return err
+}
+
+func (ctrl *VMSnapshotExportController) getBackupTarget(export *VirtualMachineSnapshotExport) (*SnapshotBackupTarget, error) {
+	key := controllerKey(export.Namespace, export.Spec.BackupTargetName)
+	obj, exists, err := ctrl.SnapshotBackupTargetInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("SnapshotBackupTarget %s does not exist", key)
+	}
+	return obj.(*SnapshotBackupTarget), nil
+}
+
+func (ctrl *VMSnapshotExportController) getContent(export *VirtualMachineSnapshotExport) (*snapshotv1.VirtualMachineSnapshotContent, error) {
+	key := controllerKey(export.Namespace, export.Spec.VirtualMachineSnapshotContentName)
+	obj, exists, err := ctrl.VMSnapshotContentInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VirtualMachineSnapshotContent %s does not exist", key)
+	}
+	return obj.(*snapshotv1.VirtualMachineSnapshotContent), nil
+}
+
+// reconcileVolumeExport drives a single volume's export to completion: the
+// VolumeSnapshot must be bound to a VolumeSnapshotContent with a populated
+// SnapshotHandle before a backup PVC is even created -- proceeding earlier
+// risks the exposed PVC binding to an incomplete snapshot and uploading a
+// corrupt backup. Once that invariant holds, it creates the backup PVC and
+// drives its uploader Pod, reporting whether the volume is fully exported.
+func (ctrl *VMSnapshotExportController) reconcileVolumeExport(export *VirtualMachineSnapshotExport, target *SnapshotBackupTarget, backup snapshotv1.VolumeBackup, volumeStatus *VolumeExportStatus) (bool, error) {
+	if backup.VolumeSnapshotName == nil {
+		return false, fmt.Errorf("no VolumeSnapshot recorded for volume %s", backup.VolumeName)
+	}
+
+	vs, err := ctrl.VolumeSnapshotProvider.GetVolumeSnapshot(export.Namespace, *backup.VolumeSnapshotName)
+	if err != nil {
+		return false, err
+	}
+	if vs == nil || vs.Status == nil || vs.Status.ReadyToUse == nil || !*vs.Status.ReadyToUse || vs.Status.BoundVolumeSnapshotContentName == nil {
+		return false, nil
+	}
+
+	vsc, err := ctrl.VolumeSnapshotContentReader.GetVolumeSnapshotContent(*vs.Status.BoundVolumeSnapshotContentName)
+	if err != nil {
+		return false, err
+	}
+	if vsc == nil || vsc.Status == nil || vsc.Status.SnapshotHandle == nil {
+		return false, nil
+	}
+
+	pvcName := fmt.Sprintf("%s-%s", export.Name, backup.VolumeName)
+	pvc, err := ctrl.ensureExportPVC(export, backup, pvcName)
+	if err != nil {
+		return false, err
+	}
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return false, nil
+	}
+
+	objectKey := fmt.Sprintf("%s%s/%s", target.Spec.Prefix, export.Name, backup.VolumeName)
+	pod, err := ctrl.ensureUploaderPod(export, target, pvcName, objectKey)
+	if err != nil {
+		return false, err
+	}
+	if pod.Status.Phase != corev1.PodSucceeded {
+		return false, nil
+	}
+
+	volumeStatus.ObjectKey = &objectKey
+	volumeStatus.ReadyToUse = &t
+	return true, nil
+}
+
+func (ctrl *VMSnapshotExportController) ensureExportPVC(export *VirtualMachineSnapshotExport, backup snapshotv1.VolumeBackup, name string) (*corev1.PersistentVolumeClaim, error) {
+	key := controllerKey(ctrl.UploadNamespace, name)
+	obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return obj.(*corev1.PersistentVolumeClaim), nil
+	}
+
+	pvc := backup.PersistentVolumeClaim.DeepCopy()
+	pvc.Name = name
+	pvc.Namespace = ctrl.UploadNamespace
+	pvc.ResourceVersion = ""
+	pvc.UID = ""
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	pvc.Annotations[exportPVCAnnotation] = export.Name
+	pvc.Spec.DataSource = &corev1.TypedLocalObjectReference{
+		APIGroup: pointer.String(vsv1.GroupName),
+		Kind:     "VolumeSnapshot",
+		Name:     *backup.VolumeSnapshotName,
+	}
+	pvc.Spec.DataSourceRef = nil
+
+	if _, err := ctrl.Client.CoreV1().PersistentVolumeClaims(ctrl.UploadNamespace).Create(context.Background(), pvc, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+func (ctrl *VMSnapshotExportController) ensureUploaderPod(export *VirtualMachineSnapshotExport, target *SnapshotBackupTarget, pvcName, objectKey string) (*corev1.Pod, error) {
+	podName := fmt.Sprintf("%s-uploader", pvcName)
+	key := controllerKey(ctrl.UploadNamespace, podName)
+	obj, exists, err := ctrl.PodInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return obj.(*corev1.Pod), nil
+	}
+
+	image := defaultDataMoverImage
+	if target.Spec.Image != "" {
+		image = target.Spec.Image
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: ctrl.UploadNamespace,
+			Annotations: map[string]string{
+				exportPodAnnotation: export.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:  "uploader",
+					Image: image,
+					Args: []string{
+						"upload",
+						"--provider", string(target.Spec.Provider),
+						"--bucket", target.Spec.Bucket,
+						"--key", objectKey,
+						"--source", "/data",
+						"--content-addressed",
+					},
+					EnvFrom: []corev1.EnvFromSource{
+						{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: target.Spec.SecretName}}},
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "data", MountPath: "/data"},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+					},
+				},
+			},
+		},
+	}
+
+	created, err := ctrl.Client.CoreV1().Pods(ctrl.UploadNamespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	if err != nil {
+		return pod, nil
+	}
+	return created, nil
+}
+
+// VMSnapshotImportController is the symmetric downloader counterpart of
+// VMSnapshotExportController: for every volume a VirtualMachineSnapshotImport
+// lists, it creates a PVC in Spec.TargetNamespace and launches a downloader
+// Pod that resolves the volume's object key from the manifest at
+// Spec.ManifestKey and streams it into the PVC.
+type VMSnapshotImportController struct {
+	Client                       kubecli.KubevirtClient
+	VMSnapshotImportInformer     cache.SharedIndexInformer
+	SnapshotBackupTargetInformer cache.SharedIndexInformer
+	PVCInformer                  cache.SharedIndexInformer
+	PodInformer                  cache.SharedIndexInformer
+	Recorder                     record.EventRecorder
+
+	vmSnapshotImportQueue workqueue.RateLimitingInterface
+}
+
+// Init wires up the controller's workqueue and informer event handlers. It
+// must be called once before Run/processVMSnapshotImportWorkItem.
+func (ctrl *VMSnapshotImportController) Init() {
+	ctrl.vmSnapshotImportQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "virt-controller-vm-snapshot-import")
+
+	ctrl.VMSnapshotImportInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueVMSnapshotImport,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.enqueueVMSnapshotImport(newObj) },
+	})
+
+	ctrl.PVCInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleImportChild,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handleImportChild(newObj) },
+	})
+
+	ctrl.PodInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleImportChild,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handleImportChild(newObj) },
+	})
+}
+
+func (ctrl *VMSnapshotImportController) enqueueVMSnapshotImport(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	ctrl.vmSnapshotImportQueue.Add(key)
+}
+
+func (ctrl *VMSnapshotImportController) handleImportChild(obj interface{}) {
+	accessor, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	name, ok := accessor.GetAnnotations()[exportPodAnnotation]
+	if !ok {
+		return
+	}
+	ctrl.vmSnapshotImportQueue.Add(controllerKey(accessor.GetNamespace(), name))
+}
+
+// Run starts the controller's worker loop and blocks until stopCh is closed.
+func (ctrl *VMSnapshotImportController) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer ctrl.vmSnapshotImportQueue.ShutDown()
+
+	log.Log.Info("Starting snapshot import controller.")
+	defer log.Log.Info("Shutting down snapshot import controller.")
+
+	if !cache.WaitForCacheSync(
+		stopCh,
+		ctrl.VMSnapshotImportInformer.HasSynced,
+		ctrl.SnapshotBackupTargetInformer.HasSynced,
+		ctrl.PVCInformer.HasSynced,
+		ctrl.PodInformer.HasSynced,
+	) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < threadiness; i++ {
+		go ctrl.worker()
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (ctrl *VMSnapshotImportController) worker() {
+	for ctrl.processVMSnapshotImportWorkItem() {
+	}
+}
+
+func (ctrl *VMSnapshotImportController) processVMSnapshotImportWorkItem() bool {
+	key, quit := ctrl.vmSnapshotImportQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.vmSnapshotImportQueue.Done(key)
+
+	if err := ctrl.updateVMSnapshotImport(key.(string)); err != nil {
+		log.Log.Reason(err).Errorf("error updating VirtualMachineSnapshotImport %s", key)
+		ctrl.vmSnapshotImportQueue.AddRateLimited(key)
+		return true
+	}
+
+	ctrl.vmSnapshotImportQueue.Forget(key)
+	return true
+}
+
+func (ctrl *VMSnapshotImportController) updateVMSnapshotImport(key string) error {
+	obj, exists, err := ctrl.VMSnapshotImportInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+
+	original := obj.(*VirtualMachineSnapshotImport)
+	if original.DeletionTimestamp != nil {
+		return nil
+	}
+
+	imp := original.DeepCopy()
+	if imp.Status == nil {
+		imp.Status = &VirtualMachineSnapshotImportStatus{ReadyToUse: &f, CreationTime: currentTime()}
+	}
+	if imp.Status.ReadyToUse != nil && *imp.Status.ReadyToUse {
+		return nil
+	}
+
+	target, err := ctrl.getBackupTarget(imp)
+	if err != nil {
+		return err
+	}
+
+	statusByVolume := make(map[string]*VolumeImportStatus, len(imp.Status.VolumeImports))
+	for i := range imp.Status.VolumeImports {
+		statusByVolume[imp.Status.VolumeImports[i].VolumeName] = &imp.Status.VolumeImports[i]
+	}
+
+	allReady := true
+	for _, volume := range imp.Spec.Volumes {
+		volumeStatus := statusByVolume[volume.Name]
+		if volumeStatus == nil {
+			imp.Status.VolumeImports = append(imp.Status.VolumeImports, VolumeImportStatus{VolumeName: volume.Name})
+			volumeStatus = &imp.Status.VolumeImports[len(imp.Status.VolumeImports)-1]
+		}
+		if volumeStatus.ReadyToUse != nil && *volumeStatus.ReadyToUse {
+			continue
+		}
+
+		ready, err := ctrl.reconcileVolumeImport(imp, target, volume, volumeStatus)
+		if err != nil {
+			volumeStatus.Error = pointer.String(err.Error())
+			allReady = false
+			continue
+		}
+		if !ready {
+			allReady = false
+		}
+	}
+
+	if allReady {
+		imp.Status.ReadyToUse = &t
+		ctrl.Recorder.Eventf(imp, corev1.EventTypeNormal, "VirtualMachineSnapshotImportComplete", "Imported manifest %s from %s", imp.Spec.ManifestKey, target.Name)
+	}
+
+	return ctrl.updateVMSnapshotImportStatus(original, imp)
+}
+
+func (ctrl *VMSnapshotImportController) updateVMSnapshotImportStatus(original, updated *VirtualMachineSnapshotImport) error {
+	if equality.Semantic.DeepEqual(original.Status, updated.Status) {
+		return nil
+	}
+	_, err := ctrl.Client.VirtualMachineSnapshotImport(updated.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	return err
+}
+
+func (ctrl *VMSnapshotImportController) getBackupTarget(imp *VirtualMachineSnapshotImport) (*SnapshotBackupTarget, error) {
+	key := controllerKey(imp.Namespace, imp.Spec.BackupTargetName)
+	obj, exists, err := ctrl.SnapshotBackupTargetInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("SnapshotBackupTarget %s does not exist", key)
+	}
+	return obj.(*SnapshotBackupTarget), nil
+}
+
+// reconcileVolumeImport creates volume's PVC in imp.Spec.TargetNamespace if
+// missing, launches its downloader Pod, and reports whether the volume is
+// fully downloaded and bound.
+func (ctrl *VMSnapshotImportController) reconcileVolumeImport(imp *VirtualMachineSnapshotImport, target *SnapshotBackupTarget, volume VirtualMachineSnapshotImportVolume, volumeStatus *VolumeImportStatus) (bool, error) {
+	pvcName := fmt.Sprintf("%s-%s", imp.Name, volume.Name)
+	pvc, err := ctrl.ensureImportPVC(imp, volume, pvcName)
+	if err != nil {
+		return false, err
+	}
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return false, nil
+	}
+
+	pod, err := ctrl.ensureDownloaderPod(imp, target, volume.Name, pvcName)
+	if err != nil {
+		return false, err
+	}
+	if pod.Status.Phase != corev1.PodSucceeded {
+		return false, nil
+	}
+
+	volumeStatus.PersistentVolumeClaimName = &pvc.Name
+	volumeStatus.ReadyToUse = &t
+	return true, nil
+}
+
+func (ctrl *VMSnapshotImportController) ensureImportPVC(imp *VirtualMachineSnapshotImport, volume VirtualMachineSnapshotImportVolume, name string) (*corev1.PersistentVolumeClaim, error) {
+	key := controllerKey(imp.Spec.TargetNamespace, name)
+	obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return obj.(*corev1.PersistentVolumeClaim), nil
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: imp.Spec.TargetNamespace,
+			Annotations: map[string]string{
+				exportPVCAnnotation: imp.Name,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: volume.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: volume.Size},
+			},
+		},
+	}
+
+	if _, err := ctrl.Client.CoreV1().PersistentVolumeClaims(imp.Spec.TargetNamespace).Create(context.Background(), pvc, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+func (ctrl *VMSnapshotImportController) ensureDownloaderPod(imp *VirtualMachineSnapshotImport, target *SnapshotBackupTarget, volumeName, pvcName string) (*corev1.Pod, error) {
+	podName := fmt.Sprintf("%s-downloader", pvcName)
+	key := controllerKey(imp.Spec.TargetNamespace, podName)
+	obj, exists, err := ctrl.PodInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return obj.(*corev1.Pod), nil
+	}
+
+	image := defaultDataMoverImage
+	if target.Spec.Image != "" {
+		image = target.Spec.Image
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: imp.Spec.TargetNamespace,
+			Annotations: map[string]string{
+				exportPodAnnotation: imp.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:  "downloader",
+					Image: image,
+					Args: []string{
+						"download",
+						"--provider", string(target.Spec.Provider),
+						"--bucket", target.Spec.Bucket,
+						"--manifest-key", imp.Spec.ManifestKey,
+						"--volume", volumeName,
+						"--destination", "/data",
+					},
+					EnvFrom: []corev1.EnvFromSource{
+						{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: target.Spec.SecretName}}},
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "data", MountPath: "/data"},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+					},
+				},
+			},
+		},
+	}
+
+	created, err := ctrl.Client.CoreV1().Pods(imp.Spec.TargetNamespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	if err != nil {
+		return pod, nil
+	}
+	return created, nil
+}
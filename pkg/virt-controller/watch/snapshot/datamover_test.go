@@ -0,0 +1,481 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package snapshot
+
+import (
+	"context"
+
+	"github.com/golang/mock/gomock"
+	vsv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/tools/record"
+
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+	kubevirtfake "kubevirt.io/client-go/generated/kubevirt/clientset/versioned/fake"
+	"kubevirt.io/client-go/kubecli"
+
+	"kubevirt.io/kubevirt/pkg/testutils"
+)
+
+// MockVolumeSnapshotContentReader is a test double for
+// VolumeSnapshotContentReader.
+type MockVolumeSnapshotContentReader struct {
+	contents map[string]*vsv1.VolumeSnapshotContent
+}
+
+func (r *MockVolumeSnapshotContentReader) GetVolumeSnapshotContent(name string) (*vsv1.VolumeSnapshotContent, error) {
+	if r.contents == nil {
+		return nil, nil
+	}
+	return r.contents[name], nil
+}
+
+func (r *MockVolumeSnapshotContentReader) CreateVolumeSnapshotContent(vsc *vsv1.VolumeSnapshotContent) (*vsv1.VolumeSnapshotContent, error) {
+	if r.contents == nil {
+		r.contents = map[string]*vsv1.VolumeSnapshotContent{}
+	}
+	r.contents[vsc.Name] = vsc
+	return vsc, nil
+}
+
+func (r *MockVolumeSnapshotContentReader) Add(vsc *vsv1.VolumeSnapshotContent) {
+	if r.contents == nil {
+		r.contents = map[string]*vsv1.VolumeSnapshotContent{}
+	}
+	r.contents[vsc.Name] = vsc
+}
+
+var _ = Describe("Snapshot export controller", func() {
+	const (
+		testNamespace   = "default"
+		uploadNamespace = "upload-ns"
+		exportName      = "export"
+		targetName      = "target"
+		contentName     = "content"
+		volumeName      = "disk1"
+		vsName          = "vmsnapshot-content-volume-disk1"
+	)
+
+	var (
+		exportInformer       cache.SharedIndexInformer
+		exportSource         *framework.FakeControllerSource
+		backupTargetInformer cache.SharedIndexInformer
+		backupTargetSource   *framework.FakeControllerSource
+		contentInformer      cache.SharedIndexInformer
+		contentSource        *framework.FakeControllerSource
+		pvcInformer          cache.SharedIndexInformer
+		pvcSource            *framework.FakeControllerSource
+		podInformer          cache.SharedIndexInformer
+		podSource            *framework.FakeControllerSource
+
+		controller     *VMSnapshotExportController
+		recorder       *record.FakeRecorder
+		k8sClient      *k8sfake.Clientset
+		kubevirtClient *kubevirtfake.Clientset
+		vsProvider     *MockVolumeSnapshotProvider
+		vscReader      *MockVolumeSnapshotContentReader
+
+		stop chan struct{}
+	)
+
+	createTarget := func() *SnapshotBackupTarget {
+		return &SnapshotBackupTarget{
+			ObjectMeta: metav1.ObjectMeta{Name: targetName, Namespace: testNamespace},
+			Spec: SnapshotBackupTargetSpec{
+				Provider:   SnapshotBackupTargetS3,
+				Bucket:     "my-bucket",
+				Prefix:     "prefix/",
+				SecretName: "creds",
+			},
+		}
+	}
+
+	createContent := func() *snapshotv1.VirtualMachineSnapshotContent {
+		return &snapshotv1.VirtualMachineSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: contentName, Namespace: testNamespace},
+			Spec: snapshotv1.VirtualMachineSnapshotContentSpec{
+				VolumeBackups: []snapshotv1.VolumeBackup{
+					{
+						VolumeName:         volumeName,
+						VolumeSnapshotName: pointerTo(vsName),
+						PersistentVolumeClaim: &snapshotv1.PersistentVolumeClaim{
+							ObjectMeta: metav1.ObjectMeta{Name: volumeName},
+							Spec: corev1.PersistentVolumeClaimSpec{
+								AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+								Resources: corev1.ResourceRequirements{
+									Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	createExport := func() *VirtualMachineSnapshotExport {
+		return &VirtualMachineSnapshotExport{
+			ObjectMeta: metav1.ObjectMeta{Name: exportName, Namespace: testNamespace},
+			Spec: VirtualMachineSnapshotExportSpec{
+				VirtualMachineSnapshotContentName: contentName,
+				BackupTargetName:                  targetName,
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		stop = make(chan struct{})
+		ctrl := gomock.NewController(GinkgoT())
+		virtClient := kubecli.NewMockKubevirtClient(ctrl)
+
+		exportInformer, exportSource = testutils.NewFakeInformerFor(&VirtualMachineSnapshotExport{})
+		backupTargetInformer, backupTargetSource = testutils.NewFakeInformerFor(&SnapshotBackupTarget{})
+		contentInformer, contentSource = testutils.NewFakeInformerFor(&snapshotv1.VirtualMachineSnapshotContent{})
+		pvcInformer, pvcSource = testutils.NewFakeInformerFor(&corev1.PersistentVolumeClaim{})
+		podInformer, podSource = testutils.NewFakeInformerFor(&corev1.Pod{})
+
+		recorder = record.NewFakeRecorder(100)
+		recorder.IncludeObject = true
+
+		vsProvider = &MockVolumeSnapshotProvider{}
+		vscReader = &MockVolumeSnapshotContentReader{}
+
+		controller = &VMSnapshotExportController{
+			Client:                       virtClient,
+			VMSnapshotExportInformer:     exportInformer,
+			SnapshotBackupTargetInformer: backupTargetInformer,
+			VMSnapshotContentInformer:    contentInformer,
+			PVCInformer:                  pvcInformer,
+			PodInformer:                  podInformer,
+			Recorder:                     recorder,
+			VolumeSnapshotProvider:       vsProvider,
+			VolumeSnapshotContentReader:  vscReader,
+			UploadNamespace:              uploadNamespace,
+		}
+		controller.Init()
+
+		kubevirtClient = kubevirtfake.NewSimpleClientset(createExport())
+		virtClient.EXPECT().VirtualMachineSnapshotExport(testNamespace).
+			Return(kubevirtClient.SnapshotV1alpha1().VirtualMachineSnapshotExports(testNamespace)).AnyTimes()
+
+		k8sClient = k8sfake.NewSimpleClientset()
+		virtClient.EXPECT().CoreV1().Return(k8sClient.CoreV1()).AnyTimes()
+
+		currentTime = func() *metav1.Time { now := metav1.Now(); return &now }
+
+		go exportInformer.Run(stop)
+		go backupTargetInformer.Run(stop)
+		go contentInformer.Run(stop)
+		go pvcInformer.Run(stop)
+		go podInformer.Run(stop)
+		Expect(cache.WaitForCacheSync(
+			stop,
+			exportInformer.HasSynced,
+			backupTargetInformer.HasSynced,
+			contentInformer.HasSynced,
+			pvcInformer.HasSynced,
+			podInformer.HasSynced,
+		)).To(BeTrue())
+
+		backupTargetSource.Add(createTarget())
+		contentSource.Add(createContent())
+	})
+
+	AfterEach(func() {
+		close(stop)
+	})
+
+	It("should not create a backup PVC until the VolumeSnapshot is bound with a SnapshotHandle", func() {
+		key := controllerKey(testNamespace, exportName)
+		export := createExport()
+		exportSource.Add(export)
+		Eventually(func() bool {
+			_, exists, _ := exportInformer.GetStore().GetByKey(key)
+			return exists
+		}).Should(BeTrue())
+
+		Expect(controller.updateVMSnapshotExport(key)).To(Succeed())
+
+		pvcs, err := k8sClient.CoreV1().PersistentVolumeClaims(uploadNamespace).List(context.Background(), metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pvcs.Items).To(BeEmpty())
+	})
+
+	It("should create a backup PVC pointed at the VolumeSnapshot once it is bound with a SnapshotHandle", func() {
+		vs := &vsv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: vsName},
+			Status: &vsv1.VolumeSnapshotStatus{
+				ReadyToUse:                     &t,
+				BoundVolumeSnapshotContentName: pointerTo(vsName + "-content"),
+			},
+		}
+		vsProvider.Add(vs)
+		vscReader.Add(&vsv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: vsName + "-content"},
+			Status: &vsv1.VolumeSnapshotContentStatus{
+				ReadyToUse:     &t,
+				SnapshotHandle: pointerTo("handle"),
+			},
+		})
+
+		key := controllerKey(testNamespace, exportName)
+		exportSource.Add(createExport())
+		Eventually(func() bool {
+			_, exists, _ := exportInformer.GetStore().GetByKey(key)
+			return exists
+		}).Should(BeTrue())
+
+		Expect(controller.updateVMSnapshotExport(key)).To(Succeed())
+
+		pvc, err := k8sClient.CoreV1().PersistentVolumeClaims(uploadNamespace).Get(context.Background(), exportName+"-"+volumeName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pvc.Spec.DataSource.Name).To(Equal(vsName))
+		Expect(pvc.Annotations[exportPVCAnnotation]).To(Equal(exportName))
+	})
+
+	It("should mark the export ready and record the manifest key once every volume's PVC is bound and its uploader Pod has succeeded", func() {
+		pvcName := exportName + "-" + volumeName
+		pvcSource.Add(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        pvcName,
+				Namespace:   uploadNamespace,
+				Annotations: map[string]string{exportPVCAnnotation: exportName},
+			},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		})
+		podSource.Add(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        pvcName + "-uploader",
+				Namespace:   uploadNamespace,
+				Annotations: map[string]string{exportPodAnnotation: exportName},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+		})
+
+		vs := &vsv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: vsName},
+			Status: &vsv1.VolumeSnapshotStatus{
+				ReadyToUse:                     &t,
+				BoundVolumeSnapshotContentName: pointerTo(vsName + "-content"),
+			},
+		}
+		vsProvider.Add(vs)
+		vscReader.Add(&vsv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: vsName + "-content"},
+			Status: &vsv1.VolumeSnapshotContentStatus{
+				ReadyToUse:     &t,
+				SnapshotHandle: pointerTo("handle"),
+			},
+		})
+
+		key := controllerKey(testNamespace, exportName)
+		exportSource.Add(createExport())
+		Eventually(func() bool {
+			_, exists, _ := exportInformer.GetStore().GetByKey(key)
+			return exists
+		}).Should(BeTrue())
+		Eventually(func() bool {
+			_, exists, _ := pvcInformer.GetStore().GetByKey(controllerKey(uploadNamespace, pvcName))
+			return exists
+		}).Should(BeTrue())
+		Eventually(func() bool {
+			_, exists, _ := podInformer.GetStore().GetByKey(controllerKey(uploadNamespace, pvcName+"-uploader"))
+			return exists
+		}).Should(BeTrue())
+
+		Expect(controller.updateVMSnapshotExport(key)).To(Succeed())
+
+		updated, err := kubevirtClient.SnapshotV1alpha1().VirtualMachineSnapshotExports(testNamespace).Get(context.Background(), exportName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*updated.Status.ReadyToUse).To(BeTrue())
+		Expect(updated.Status.ManifestKey).ToNot(BeNil())
+	})
+})
+
+var _ = Describe("Snapshot import controller", func() {
+	const (
+		testNamespace   = "default"
+		targetNamespace = "target-ns"
+		importName      = "import"
+		targetName      = "target"
+		volumeName      = "disk1"
+	)
+
+	var (
+		importInformer       cache.SharedIndexInformer
+		importSource         *framework.FakeControllerSource
+		backupTargetInformer cache.SharedIndexInformer
+		backupTargetSource   *framework.FakeControllerSource
+		pvcInformer          cache.SharedIndexInformer
+		pvcSource            *framework.FakeControllerSource
+		podInformer          cache.SharedIndexInformer
+		podSource            *framework.FakeControllerSource
+
+		controller     *VMSnapshotImportController
+		recorder       *record.FakeRecorder
+		k8sClient      *k8sfake.Clientset
+		kubevirtClient *kubevirtfake.Clientset
+
+		stop chan struct{}
+	)
+
+	createTarget := func() *SnapshotBackupTarget {
+		return &SnapshotBackupTarget{
+			ObjectMeta: metav1.ObjectMeta{Name: targetName, Namespace: testNamespace},
+			Spec: SnapshotBackupTargetSpec{
+				Provider:   SnapshotBackupTargetS3,
+				Bucket:     "my-bucket",
+				SecretName: "creds",
+			},
+		}
+	}
+
+	createImport := func() *VirtualMachineSnapshotImport {
+		return &VirtualMachineSnapshotImport{
+			ObjectMeta: metav1.ObjectMeta{Name: importName, Namespace: testNamespace},
+			Spec: VirtualMachineSnapshotImportSpec{
+				BackupTargetName: targetName,
+				ManifestKey:      "prefix/export/manifest.json",
+				TargetNamespace:  targetNamespace,
+				Volumes: []VirtualMachineSnapshotImportVolume{
+					{Name: volumeName, Size: resource.MustParse("1Gi")},
+				},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		stop = make(chan struct{})
+		ctrl := gomock.NewController(GinkgoT())
+		virtClient := kubecli.NewMockKubevirtClient(ctrl)
+
+		importInformer, importSource = testutils.NewFakeInformerFor(&VirtualMachineSnapshotImport{})
+		backupTargetInformer, backupTargetSource = testutils.NewFakeInformerFor(&SnapshotBackupTarget{})
+		pvcInformer, pvcSource = testutils.NewFakeInformerFor(&corev1.PersistentVolumeClaim{})
+		podInformer, podSource = testutils.NewFakeInformerFor(&corev1.Pod{})
+
+		recorder = record.NewFakeRecorder(100)
+		recorder.IncludeObject = true
+
+		controller = &VMSnapshotImportController{
+			Client:                       virtClient,
+			VMSnapshotImportInformer:     importInformer,
+			SnapshotBackupTargetInformer: backupTargetInformer,
+			PVCInformer:                  pvcInformer,
+			PodInformer:                  podInformer,
+			Recorder:                     recorder,
+		}
+		controller.Init()
+
+		kubevirtClient = kubevirtfake.NewSimpleClientset(createImport())
+		virtClient.EXPECT().VirtualMachineSnapshotImport(testNamespace).
+			Return(kubevirtClient.SnapshotV1alpha1().VirtualMachineSnapshotImports(testNamespace)).AnyTimes()
+
+		k8sClient = k8sfake.NewSimpleClientset()
+		virtClient.EXPECT().CoreV1().Return(k8sClient.CoreV1()).AnyTimes()
+
+		currentTime = func() *metav1.Time { now := metav1.Now(); return &now }
+
+		go importInformer.Run(stop)
+		go backupTargetInformer.Run(stop)
+		go pvcInformer.Run(stop)
+		go podInformer.Run(stop)
+		Expect(cache.WaitForCacheSync(
+			stop,
+			importInformer.HasSynced,
+			backupTargetInformer.HasSynced,
+			pvcInformer.HasSynced,
+			podInformer.HasSynced,
+		)).To(BeTrue())
+
+		backupTargetSource.Add(createTarget())
+	})
+
+	AfterEach(func() {
+		close(stop)
+	})
+
+	It("should create an import PVC in the target namespace for each volume", func() {
+		key := controllerKey(testNamespace, importName)
+		importSource.Add(createImport())
+		Eventually(func() bool {
+			_, exists, _ := importInformer.GetStore().GetByKey(key)
+			return exists
+		}).Should(BeTrue())
+
+		Expect(controller.updateVMSnapshotImport(key)).To(Succeed())
+
+		pvc, err := k8sClient.CoreV1().PersistentVolumeClaims(targetNamespace).Get(context.Background(), importName+"-"+volumeName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pvc.Annotations[exportPVCAnnotation]).To(Equal(importName))
+	})
+
+	It("should mark the import ready once every volume's PVC is bound and its downloader Pod has succeeded", func() {
+		pvcName := importName + "-" + volumeName
+		pvcSource.Add(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        pvcName,
+				Namespace:   targetNamespace,
+				Annotations: map[string]string{exportPVCAnnotation: importName},
+			},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		})
+		podSource.Add(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        pvcName + "-downloader",
+				Namespace:   targetNamespace,
+				Annotations: map[string]string{exportPodAnnotation: importName},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+		})
+
+		key := controllerKey(testNamespace, importName)
+		importSource.Add(createImport())
+		Eventually(func() bool {
+			_, exists, _ := importInformer.GetStore().GetByKey(key)
+			return exists
+		}).Should(BeTrue())
+		Eventually(func() bool {
+			_, exists, _ := pvcInformer.GetStore().GetByKey(controllerKey(targetNamespace, pvcName))
+			return exists
+		}).Should(BeTrue())
+		Eventually(func() bool {
+			_, exists, _ := podInformer.GetStore().GetByKey(controllerKey(targetNamespace, pvcName+"-downloader"))
+			return exists
+		}).Should(BeTrue())
+
+		Expect(controller.updateVMSnapshotImport(key)).To(Succeed())
+
+		updated, err := kubevirtClient.SnapshotV1alpha1().VirtualMachineSnapshotImports(testNamespace).Get(context.Background(), importName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*updated.Status.ReadyToUse).To(BeTrue())
+	})
+})
+
+func pointerTo[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,586 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	v1 "kubevirt.io/api/core/v1"
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+	"kubevirt.io/client-go/kubecli"
+	"kubevirt.io/client-go/log"
+)
+
+// diskRestorePVCAnnotation marks a PVC materialized by the disk-restore
+// controller as belonging to a given VirtualMachineDiskRestore, so the PVC
+// informer's event handler knows which disk-restore to requeue when it
+// changes. This is deliberately a distinct key from restorePVCAnnotation:
+// VirtualMachineRestore and VirtualMachineDiskRestore are independent CRDs
+// that can share a name in the same namespace, and collapsing both onto one
+// annotation would let one kind's PVC events enqueue the other controller.
+const diskRestorePVCAnnotation = "restore.kubevirt.io/disk-restore-name"
+
+// VirtualMachineDiskSnapshot captures a single DataVolume or PersistentVolumeClaim
+// as a CSI VolumeSnapshot, independent of any VirtualMachineSnapshot of the VM
+// the disk may be attached to. Its Status.VirtualMachineDiskSnapshotContentName
+// points at the VirtualMachineDiskSnapshotContent recording the result once the
+// underlying VolumeSnapshot is ready.
+type VirtualMachineDiskSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualMachineDiskSnapshotSpec    `json:"spec"`
+	Status *VirtualMachineDiskSnapshotStatus `json:"status,omitempty"`
+}
+
+// VirtualMachineDiskSnapshotSpec identifies the disk to snapshot. Source
+// names either a DataVolume or a PersistentVolumeClaim in the same namespace.
+type VirtualMachineDiskSnapshotSpec struct {
+	Source corev1.TypedLocalObjectReference `json:"source"`
+}
+
+type VirtualMachineDiskSnapshotStatus struct {
+	ReadyToUse                            *bool        `json:"readyToUse,omitempty"`
+	CreationTime                          *metav1.Time `json:"creationTime,omitempty"`
+	VirtualMachineDiskSnapshotContentName *string      `json:"virtualMachineDiskSnapshotContentName,omitempty"`
+	Error                                 *string      `json:"error,omitempty"`
+}
+
+// VirtualMachineDiskSnapshotContent is the immutable record a
+// VirtualMachineDiskSnapshot produces: the name of the CSI VolumeSnapshot
+// backing it and the size of the PVC it was taken from, so a disk-restore can
+// size the PVC it materializes without looking the source PVC back up.
+type VirtualMachineDiskSnapshotContent struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualMachineDiskSnapshotContentSpec    `json:"spec"`
+	Status *VirtualMachineDiskSnapshotContentStatus `json:"status,omitempty"`
+}
+
+type VirtualMachineDiskSnapshotContentSpec struct {
+	VirtualMachineDiskSnapshotName *string                           `json:"virtualMachineDiskSnapshotName,omitempty"`
+	VolumeSnapshotName             *string                           `json:"volumeSnapshotName,omitempty"`
+	SourcePVC                      *corev1.PersistentVolumeClaimSpec `json:"sourcePVC,omitempty"`
+}
+
+type VirtualMachineDiskSnapshotContentStatus struct {
+	ReadyToUse   *bool        `json:"readyToUse,omitempty"`
+	CreationTime *metav1.Time `json:"creationTime,omitempty"`
+}
+
+// VirtualMachineDiskRestore materializes a PersistentVolumeClaim from a
+// VirtualMachineDiskSnapshot, independent of any VM. The resulting PVC can be
+// attached to any VM via hotplug once Status.Complete is true.
+type VirtualMachineDiskRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualMachineDiskRestoreSpec    `json:"spec"`
+	Status *VirtualMachineDiskRestoreStatus `json:"status,omitempty"`
+}
+
+type VirtualMachineDiskRestoreSpec struct {
+	// VirtualMachineDiskSnapshotName sources the restore from a
+	// VirtualMachineDiskSnapshot, taken independently of any VM. Mutually
+	// exclusive with SnapshotName/VolumeName.
+	VirtualMachineDiskSnapshotName string `json:"virtualMachineDiskSnapshotName,omitempty"`
+	// SnapshotName and VolumeName source the restore from a single volume
+	// of a whole-VM VirtualMachineSnapshot instead, letting a user pull one
+	// disk out of an otherwise VM-wide snapshot without restoring the rest
+	// of the VM. Mutually exclusive with VirtualMachineDiskSnapshotName.
+	SnapshotName string `json:"snapshotName,omitempty"`
+	VolumeName   string `json:"volumeName,omitempty"`
+	// TargetClaimName is the name given to the restored PersistentVolumeClaim.
+	TargetClaimName string `json:"targetClaimName"`
+	// StorageClassName overrides the restored PVC's storage class; if unset,
+	// the source PVC's storage class is reused.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// TargetVMName attaches the restored PVC to an existing VM as a disk
+	// named VolumeName, instead of leaving it as a bare PVC for the user to
+	// hotplug in. The VM must be stopped: updateVMDiskRestore blocks with a
+	// not-ready condition for as long as it has a running VMI.
+	TargetVMName *string `json:"targetVMName,omitempty"`
+}
+
+type VirtualMachineDiskRestoreStatus struct {
+	Complete                  *bool                  `json:"complete,omitempty"`
+	RestoreTime               *metav1.Time           `json:"restoreTime,omitempty"`
+	Conditions                []snapshotv1.Condition `json:"conditions,omitempty"`
+	PersistentVolumeClaimName *string                `json:"persistentVolumeClaimName,omitempty"`
+}
+
+// VMDiskRestoreController materializes a VirtualMachineDiskRestore's target
+// PVC, either from the VolumeSnapshot recorded by a
+// VirtualMachineDiskSnapshotContent or from a single volume of a whole-VM
+// VirtualMachineSnapshotContent (Spec.SnapshotName/VolumeName). It reuses
+// VolumeSnapshotProvider and the restoreSize sizing rule from the whole-VM
+// VMRestoreController. The restored PVC is left for the user to hotplug in,
+// unless Spec.TargetVMName names a stopped VM to attach it to directly.
+type VMDiskRestoreController struct {
+	Client                        kubecli.KubevirtClient
+	VMDiskRestoreInformer         cache.SharedIndexInformer
+	VMDiskSnapshotInformer        cache.SharedIndexInformer
+	VMDiskSnapshotContentInformer cache.SharedIndexInformer
+	VMSnapshotInformer            cache.SharedIndexInformer
+	VMSnapshotContentInformer     cache.SharedIndexInformer
+	VMInformer                    cache.SharedIndexInformer
+	VMIInformer                   cache.SharedIndexInformer
+	PVCInformer                   cache.SharedIndexInformer
+	Recorder                      record.EventRecorder
+	VolumeSnapshotProvider        VolumeSnapshotProvider
+
+	vmDiskRestoreQueue workqueue.RateLimitingInterface
+}
+
+// Init wires up the controller's workqueue and informer event handlers. It
+// must be called once before Run/processVMDiskRestoreWorkItem.
+func (ctrl *VMDiskRestoreController) Init() {
+	ctrl.vmDiskRestoreQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "virt-controller-vm-disk-restore")
+
+	ctrl.VMDiskRestoreInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueVMDiskRestore,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.enqueueVMDiskRestore(newObj) },
+	})
+
+	ctrl.PVCInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleDiskRestorePVC,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handleDiskRestorePVC(newObj) },
+	})
+
+	ctrl.VMIInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleDiskRestoreVMI,
+		DeleteFunc: ctrl.handleDiskRestoreVMI,
+	})
+}
+
+func (ctrl *VMDiskRestoreController) enqueueVMDiskRestore(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	ctrl.vmDiskRestoreQueue.Add(key)
+}
+
+func (ctrl *VMDiskRestoreController) handleDiskRestorePVC(obj interface{}) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return
+	}
+	name, ok := pvc.Annotations[diskRestorePVCAnnotation]
+	if !ok {
+		return
+	}
+	ctrl.vmDiskRestoreQueue.Add(controllerKey(pvc.Namespace, name))
+}
+
+// handleDiskRestoreVMI requeues every VirtualMachineDiskRestore targeting
+// the VM a VMI just appeared on or disappeared from -- there is no
+// annotation linking a VMI back to the disk-restores waiting on it, so this
+// scans the (small, namespaced-by-informer-cache) VMDiskRestoreInformer
+// store, same as VMRestoreController.handleVM does via a direct
+// RestoreInProgress pointer where that pointer is available.
+func (ctrl *VMDiskRestoreController) handleDiskRestoreVMI(obj interface{}) {
+	vmi, ok := obj.(*v1.VirtualMachineInstance)
+	if !ok {
+		return
+	}
+	for _, obj := range ctrl.VMDiskRestoreInformer.GetStore().List() {
+		diskRestore := obj.(*VirtualMachineDiskRestore)
+		if diskRestore.Namespace == vmi.Namespace && diskRestore.Spec.TargetVMName != nil && *diskRestore.Spec.TargetVMName == vmi.Name {
+			ctrl.vmDiskRestoreQueue.Add(controllerKey(diskRestore.Namespace, diskRestore.Name))
+		}
+	}
+}
+
+// Run starts the controller's worker loop and blocks until stopCh is closed.
+func (ctrl *VMDiskRestoreController) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer ctrl.vmDiskRestoreQueue.ShutDown()
+
+	log.Log.Info("Starting disk restore controller.")
+	defer log.Log.Info("Shutting down disk restore controller.")
+
+	if !cache.WaitForCacheSync(
+		stopCh,
+		ctrl.VMDiskRestoreInformer.HasSynced,
+		ctrl.VMDiskSnapshotInformer.HasSynced,
+		ctrl.VMDiskSnapshotContentInformer.HasSynced,
+		ctrl.VMSnapshotInformer.HasSynced,
+		ctrl.VMSnapshotContentInformer.HasSynced,
+		ctrl.VMInformer.HasSynced,
+		ctrl.VMIInformer.HasSynced,
+		ctrl.PVCInformer.HasSynced,
+	) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < threadiness; i++ {
+		go ctrl.worker()
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (ctrl *VMDiskRestoreController) worker() {
+	for ctrl.processVMDiskRestoreWorkItem() {
+	}
+}
+
+func (ctrl *VMDiskRestoreController) processVMDiskRestoreWorkItem() bool {
+	key, quit := ctrl.vmDiskRestoreQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.vmDiskRestoreQueue.Done(key)
+
+	if err := ctrl.updateVMDiskRestore(key.(string)); err != nil {
+		log.Log.Reason(err).Errorf("error updating VirtualMachineDiskRestore %s", key)
+		ctrl.vmDiskRestoreQueue.AddRateLimited(key)
+		return true
+	}
+
+	ctrl.vmDiskRestoreQueue.Forget(key)
+	return true
+}
+
+func (ctrl *VMDiskRestoreController) updateVMDiskRestore(key string) error {
+	obj, exists, err := ctrl.VMDiskRestoreInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+
+	original := obj.(*VirtualMachineDiskRestore)
+	if original.DeletionTimestamp != nil {
+		return nil
+	}
+
+	diskRestore := original.DeepCopy()
+	if diskRestore.Status == nil {
+		diskRestore.Status = &VirtualMachineDiskRestoreStatus{Complete: &f}
+	}
+
+	if diskRestore.Status.Complete != nil && *diskRestore.Status.Complete {
+		return nil
+	}
+
+	source, err := ctrl.resolveDiskRestoreSource(diskRestore)
+	if err != nil {
+		ctrl.Recorder.Eventf(diskRestore, corev1.EventTypeWarning, "VirtualMachineDiskRestoreError", err.Error())
+		diskRestore.Status.Conditions = []snapshotv1.Condition{newReadyCondition(corev1.ConditionFalse, err.Error())}
+		if uerr := ctrl.updateVMDiskRestoreStatus(original, diskRestore); uerr != nil {
+			return uerr
+		}
+		return err
+	}
+
+	// Owning the VirtualMachineDiskRestore by the snapshot it restores from
+	// mirrors vmRestoreTarget.OwnerReference() for whole-VM restores: once
+	// set, deleting the source VirtualMachineDiskSnapshot/VirtualMachineSnapshot
+	// garbage-collects this now-unrestorable restore object along with it.
+	if len(diskRestore.OwnerReferences) == 0 {
+		diskRestore.OwnerReferences = []metav1.OwnerReference{source.owner}
+		diskRestore.Status.Conditions = []snapshotv1.Condition{newReadyCondition(corev1.ConditionFalse, "Initializing VirtualMachineDiskRestore")}
+		return ctrl.updateVMDiskRestoreStatus(original, diskRestore)
+	}
+
+	targetVM, err := ctrl.getTargetVM(diskRestore)
+	if err != nil {
+		ctrl.Recorder.Eventf(diskRestore, corev1.EventTypeWarning, "VirtualMachineDiskRestoreError", err.Error())
+		diskRestore.Status.Conditions = []snapshotv1.Condition{newReadyCondition(corev1.ConditionFalse, err.Error())}
+		if uerr := ctrl.updateVMDiskRestoreStatus(original, diskRestore); uerr != nil {
+			return uerr
+		}
+		return err
+	}
+
+	pvc, bound, err := ctrl.reconcileDiskRestorePVC(diskRestore, source)
+	if err != nil {
+		return err
+	}
+
+	if !bound {
+		diskRestore.Status.Conditions = []snapshotv1.Condition{newReadyCondition(corev1.ConditionFalse, "Waiting for restored PVC to be bound")}
+		return ctrl.updateVMDiskRestoreStatus(original, diskRestore)
+	}
+
+	if targetVM != nil {
+		hasVMI, err := ctrl.targetVMIExists(targetVM)
+		if err != nil {
+			return err
+		}
+		if hasVMI {
+			diskRestore.Status.Conditions = []snapshotv1.Condition{newReadyCondition(corev1.ConditionFalse, "Waiting for target VM to be stopped")}
+			return ctrl.updateVMDiskRestoreStatus(original, diskRestore)
+		}
+
+		if err := ctrl.attachRestoredVolume(targetVM, diskRestore.Spec.VolumeName, pvc.Name); err != nil {
+			return err
+		}
+	}
+
+	diskRestore.Status.PersistentVolumeClaimName = &pvc.Name
+	diskRestore.Status.Complete = &t
+	diskRestore.Status.RestoreTime = currentTime()
+	diskRestore.Status.Conditions = []snapshotv1.Condition{newReadyCondition(corev1.ConditionTrue, "Operation complete")}
+	ctrl.Recorder.Eventf(diskRestore, corev1.EventTypeNormal, "VirtualMachineDiskRestoreComplete", "Created PVC %s", pvc.Name)
+	return ctrl.updateVMDiskRestoreStatus(original, diskRestore)
+}
+
+func (ctrl *VMDiskRestoreController) updateVMDiskRestoreStatus(original, updated *VirtualMachineDiskRestore) error {
+	if equality.Semantic.DeepEqual(original.Status, updated.Status) {
+		return nil
+	}
+	_, err := ctrl.Client.VirtualMachineDiskRestore(updated.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	return err
+}
+
+// diskRestoreSource is the PVC template and VolumeSnapshot a
+// VirtualMachineDiskRestore materializes its target PVC from, regardless of
+// whether it came from a VirtualMachineDiskSnapshotContent
+// (Spec.VirtualMachineDiskSnapshotName) or a single volume of a whole-VM
+// VirtualMachineSnapshotContent (Spec.SnapshotName/VolumeName).
+type diskRestoreSource struct {
+	pvcSpec            *corev1.PersistentVolumeClaimSpec
+	volumeSnapshotName string
+	// owner is set on diskRestore.OwnerReferences once the source resolves,
+	// mirroring vmRestoreTarget.OwnerReference()'s role for whole-VM restores:
+	// deleting the VirtualMachineDiskSnapshot or VirtualMachineSnapshot this
+	// restore was taken from garbage-collects the (by then stale) restore
+	// object along with it.
+	owner metav1.OwnerReference
+}
+
+// resolveDiskRestoreSource dispatches on which of diskRestore's two mutually
+// exclusive source fields is set and returns a diskRestoreSource describing
+// the volume to restore.
+func (ctrl *VMDiskRestoreController) resolveDiskRestoreSource(diskRestore *VirtualMachineDiskRestore) (*diskRestoreSource, error) {
+	if diskRestore.Spec.VirtualMachineDiskSnapshotName != "" {
+		return ctrl.resolveDiskSnapshotSource(diskRestore)
+	}
+	return ctrl.resolveVMSnapshotSource(diskRestore)
+}
+
+func (ctrl *VMDiskRestoreController) resolveDiskSnapshotSource(diskRestore *VirtualMachineDiskRestore) (*diskRestoreSource, error) {
+	key := controllerKey(diskRestore.Namespace, diskRestore.Spec.VirtualMachineDiskSnapshotName)
+	obj, exists, err := ctrl.VMDiskSnapshotInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VirtualMachineDiskSnapshot %s does not exist", key)
+	}
+
+	diskSnapshot := obj.(*VirtualMachineDiskSnapshot)
+	if diskSnapshot.Status == nil || diskSnapshot.Status.ReadyToUse == nil || !*diskSnapshot.Status.ReadyToUse {
+		return nil, fmt.Errorf("VirtualMachineDiskSnapshot %s is not ready", key)
+	}
+
+	contentKey := controllerKey(diskRestore.Namespace, *diskSnapshot.Status.VirtualMachineDiskSnapshotContentName)
+	contentObj, exists, err := ctrl.VMDiskSnapshotContentInformer.GetStore().GetByKey(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VirtualMachineDiskSnapshotContent %s does not exist", contentKey)
+	}
+
+	content := contentObj.(*VirtualMachineDiskSnapshotContent)
+	if content.Spec.SourcePVC == nil || content.Spec.VolumeSnapshotName == nil {
+		return nil, fmt.Errorf("VirtualMachineDiskSnapshotContent %s is incomplete", content.Name)
+	}
+	return &diskRestoreSource{
+		pvcSpec:            content.Spec.SourcePVC,
+		volumeSnapshotName: *content.Spec.VolumeSnapshotName,
+		owner: metav1.OwnerReference{
+			APIVersion:         snapshotv1.GroupVersion.String(),
+			Kind:               "VirtualMachineDiskSnapshot",
+			Name:               diskSnapshot.Name,
+			UID:                diskSnapshot.UID,
+			Controller:         &t,
+			BlockOwnerDeletion: &t,
+		},
+	}, nil
+}
+
+// resolveVMSnapshotSource pulls diskRestore.Spec.VolumeName's backup out of
+// the whole-VM VirtualMachineSnapshotContent named by Spec.SnapshotName,
+// reusing the same VolumeBackups list VMRestoreController restores every
+// volume from.
+func (ctrl *VMDiskRestoreController) resolveVMSnapshotSource(diskRestore *VirtualMachineDiskRestore) (*diskRestoreSource, error) {
+	content, err := resolveVMSnapshotContent(ctrl.VMSnapshotInformer, ctrl.VMSnapshotContentInformer, diskRestore.Namespace, diskRestore.Spec.SnapshotName)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := findVolumeBackup(content, diskRestore.Spec.VolumeName)
+	if backup == nil || backup.PersistentVolumeClaim == nil || backup.VolumeSnapshotName == nil {
+		return nil, fmt.Errorf("VirtualMachineSnapshotContent %s has no restorable backup for volume %s", content.Name, diskRestore.Spec.VolumeName)
+	}
+
+	snapshotKey := controllerKey(diskRestore.Namespace, diskRestore.Spec.SnapshotName)
+	snapshotObj, exists, err := ctrl.VMSnapshotInformer.GetStore().GetByKey(snapshotKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VMSnapshot %s does not exist", snapshotKey)
+	}
+	vmSnapshot := snapshotObj.(*snapshotv1.VirtualMachineSnapshot)
+
+	return &diskRestoreSource{
+		pvcSpec:            &backup.PersistentVolumeClaim.Spec,
+		volumeSnapshotName: *backup.VolumeSnapshotName,
+		owner: metav1.OwnerReference{
+			APIVersion:         snapshotv1.GroupVersion.String(),
+			Kind:               "VirtualMachineSnapshot",
+			Name:               vmSnapshot.Name,
+			UID:                vmSnapshot.UID,
+			Controller:         &t,
+			BlockOwnerDeletion: &t,
+		},
+	}, nil
+}
+
+// reconcileDiskRestorePVC creates diskRestore's target PVC from source if it
+// does not already exist, and reports whether it is bound. Mirrors
+// VMRestoreController.reconcileVolumeRestorePVCs: a freshly created PVC is
+// reported not-yet-bound, and its status is picked up on a later PVC informer
+// event rather than by re-reading the API server synchronously.
+func (ctrl *VMDiskRestoreController) reconcileDiskRestorePVC(diskRestore *VirtualMachineDiskRestore, source *diskRestoreSource) (*corev1.PersistentVolumeClaim, bool, error) {
+	pvcKey := controllerKey(diskRestore.Namespace, diskRestore.Spec.TargetClaimName)
+	obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(pvcKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if exists {
+		pvc := obj.(*corev1.PersistentVolumeClaim)
+		return pvc, pvc.Status.Phase == corev1.ClaimBound, nil
+	}
+
+	dataSource, size, err := snapshotRestoreDataSource(ctrl.VolumeSnapshotProvider, diskRestore.Namespace, source.volumeSnapshotName, source.pvcSpec.Resources.Requests[corev1.ResourceStorage])
+	if err != nil {
+		return nil, false, err
+	}
+
+	storageClassName := source.pvcSpec.StorageClassName
+	if diskRestore.Spec.StorageClassName != nil {
+		storageClassName = diskRestore.Spec.StorageClassName
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      diskRestore.Spec.TargetClaimName,
+			Namespace: diskRestore.Namespace,
+			Annotations: map[string]string{
+				diskRestorePVCAnnotation: diskRestore.Name,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      source.pvcSpec.AccessModes,
+			VolumeMode:       source.pvcSpec.VolumeMode,
+			StorageClassName: storageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+			DataSource: dataSource,
+		},
+	}
+
+	// A VirtualMachineDiskRestore has no VolumeRestoreOverrides/ResourceModifiers
+	// of its own (unlike VirtualMachineRestoreSpec), so both arguments are nil;
+	// finalizeRestorePVC is a no-op in that case and this only exists so the two
+	// controllers' PVC-creation paths share one place that applies them.
+	if _, err := finalizeRestorePVC(pvc, nil, nil); err != nil {
+		return nil, false, err
+	}
+
+	if _, err := ctrl.Client.CoreV1().PersistentVolumeClaims(diskRestore.Namespace).Create(context.Background(), pvc, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, false, err
+	}
+	return pvc, false, nil
+}
+
+// getTargetVM resolves Spec.TargetVMName, returning nil (no error) when it
+// is unset -- most disk restores leave their PVC bare for the user to
+// hotplug in.
+func (ctrl *VMDiskRestoreController) getTargetVM(diskRestore *VirtualMachineDiskRestore) (*v1.VirtualMachine, error) {
+	if diskRestore.Spec.TargetVMName == nil {
+		return nil, nil
+	}
+
+	key := controllerKey(diskRestore.Namespace, *diskRestore.Spec.TargetVMName)
+	obj, exists, err := ctrl.VMInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VirtualMachine %s does not exist", key)
+	}
+	return obj.(*v1.VirtualMachine).DeepCopy(), nil
+}
+
+// targetVMIExists mirrors VMRestoreController.targetVMIExists: a disk
+// restore with a TargetVMName must not touch that VM's spec while it has a
+// running VMI.
+func (ctrl *VMDiskRestoreController) targetVMIExists(vm *v1.VirtualMachine) (bool, error) {
+	_, exists, err := ctrl.VMIInformer.GetStore().GetByKey(controllerKey(vm.Namespace, vm.Name))
+	return exists, err
+}
+
+// attachRestoredVolume adds pvcName as a PersistentVolumeClaim volume (and a
+// matching virtio disk) to vm's template spec under volumeName, if not
+// already present, and persists the change. The caller has already
+// confirmed vm has no running VMI.
+func (ctrl *VMDiskRestoreController) attachRestoredVolume(vm *v1.VirtualMachine, volumeName, pvcName string) error {
+	for _, volume := range vm.Spec.Template.Spec.Volumes {
+		if volume.Name == volumeName {
+			return nil
+		}
+	}
+
+	updated := vm.DeepCopy()
+	updated.Spec.Template.Spec.Volumes = append(updated.Spec.Template.Spec.Volumes, v1.Volume{
+		Name: volumeName,
+		VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+			},
+		},
+	})
+	updated.Spec.Template.Spec.Domain.Devices.Disks = append(updated.Spec.Template.Spec.Domain.Devices.Disks, v1.Disk{
+		Name: volumeName,
+		DiskDevice: v1.DiskDevice{
+			Disk: &v1.DiskTarget{Bus: v1.DiskBusVirtio},
+		},
+	})
+
+	_, err := ctrl.Client.VirtualMachine(vm.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,370 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package snapshot
+
+import (
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	framework "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/tools/record"
+
+	v1 "kubevirt.io/api/core/v1"
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+	kubevirtfake "kubevirt.io/client-go/generated/kubevirt/clientset/versioned/fake"
+	"kubevirt.io/client-go/kubecli"
+
+	"kubevirt.io/kubevirt/pkg/testutils"
+)
+
+var _ = Describe("Disk restore controller", func() {
+	const (
+		diskRestoreTestNamespace = "default"
+		diskRestoreVMName        = "testvm"
+		diskSnapshotName         = "disksnapshot"
+		diskRestoreName          = "diskrestore"
+		targetClaimName          = "restored-disk"
+	)
+
+	var (
+		diskRestoreSource       *framework.FakeControllerSource
+		diskRestoreInformer     cache.SharedIndexInformer
+		diskSnapshotSource      *framework.FakeControllerSource
+		diskSnapshotInformer    cache.SharedIndexInformer
+		diskSnapContentSource   *framework.FakeControllerSource
+		diskSnapContentInformer cache.SharedIndexInformer
+		vmSnapshotSource        *framework.FakeControllerSource
+		vmSnapshotInformer      cache.SharedIndexInformer
+		vmSnapshotContentSource *framework.FakeControllerSource
+		vmSnapshotContentInf    cache.SharedIndexInformer
+		vmSource                *framework.FakeControllerSource
+		vmInformer              cache.SharedIndexInformer
+		vmiSource               *framework.FakeControllerSource
+		vmiInformer             cache.SharedIndexInformer
+		pvcSource               *framework.FakeControllerSource
+		pvcInformer             cache.SharedIndexInformer
+
+		stop       chan struct{}
+		controller *VMDiskRestoreController
+		recorder   *record.FakeRecorder
+		mockQueue  *testutils.MockWorkQueue
+
+		ctrl           *gomock.Controller
+		vmInterface    *kubecli.MockVirtualMachineInterface
+		kubevirtClient *kubevirtfake.Clientset
+		k8sClient      *k8sfake.Clientset
+		volumeProvider *MockVolumeSnapshotProvider
+	)
+
+	diskRestoreTimeStamp := metav1.Now()
+	diskRestoreTimeFunc := func() *metav1.Time {
+		return &diskRestoreTimeStamp
+	}
+
+	syncCaches := func(stop chan struct{}) {
+		go diskRestoreInformer.Run(stop)
+		go diskSnapshotInformer.Run(stop)
+		go diskSnapContentInformer.Run(stop)
+		go vmSnapshotInformer.Run(stop)
+		go vmSnapshotContentInf.Run(stop)
+		go vmInformer.Run(stop)
+		go vmiInformer.Run(stop)
+		go pvcInformer.Run(stop)
+		Expect(cache.WaitForCacheSync(
+			stop,
+			diskRestoreInformer.HasSynced,
+			diskSnapshotInformer.HasSynced,
+			diskSnapContentInformer.HasSynced,
+			vmSnapshotInformer.HasSynced,
+			vmSnapshotContentInf.HasSynced,
+			vmInformer.HasSynced,
+			vmiInformer.HasSynced,
+			pvcInformer.HasSynced,
+		)).To(BeTrue())
+	}
+
+	BeforeEach(func() {
+		stop = make(chan struct{})
+		ctrl = gomock.NewController(GinkgoT())
+		virtClient := kubecli.NewMockKubevirtClient(ctrl)
+		vmInterface = kubecli.NewMockVirtualMachineInterface(ctrl)
+
+		diskRestoreInformer, diskRestoreSource = testutils.NewFakeInformerFor(&VirtualMachineDiskRestore{})
+		diskSnapshotInformer, diskSnapshotSource = testutils.NewFakeInformerFor(&VirtualMachineDiskSnapshot{})
+		diskSnapContentInformer, diskSnapContentSource = testutils.NewFakeInformerFor(&VirtualMachineDiskSnapshotContent{})
+		vmSnapshotInformer, vmSnapshotSource = testutils.NewFakeInformerFor(&snapshotv1.VirtualMachineSnapshot{})
+		vmSnapshotContentInf, vmSnapshotContentSource = testutils.NewFakeInformerFor(&snapshotv1.VirtualMachineSnapshotContent{})
+		vmInformer, vmSource = testutils.NewFakeInformerFor(&v1.VirtualMachine{})
+		vmiInformer, vmiSource = testutils.NewFakeInformerFor(&v1.VirtualMachineInstance{})
+		pvcInformer, pvcSource = testutils.NewFakeInformerFor(&corev1.PersistentVolumeClaim{})
+
+		recorder = record.NewFakeRecorder(100)
+		recorder.IncludeObject = true
+
+		volumeProvider = &MockVolumeSnapshotProvider{}
+
+		controller = &VMDiskRestoreController{
+			Client:                        virtClient,
+			VMDiskRestoreInformer:         diskRestoreInformer,
+			VMDiskSnapshotInformer:        diskSnapshotInformer,
+			VMDiskSnapshotContentInformer: diskSnapContentInformer,
+			VMSnapshotInformer:            vmSnapshotInformer,
+			VMSnapshotContentInformer:     vmSnapshotContentInf,
+			VMInformer:                    vmInformer,
+			VMIInformer:                   vmiInformer,
+			PVCInformer:                   pvcInformer,
+			Recorder:                      recorder,
+			VolumeSnapshotProvider:        volumeProvider,
+		}
+		controller.Init()
+
+		mockQueue = testutils.NewMockWorkQueue(controller.vmDiskRestoreQueue)
+		controller.vmDiskRestoreQueue = mockQueue
+
+		virtClient.EXPECT().VirtualMachine(diskRestoreTestNamespace).Return(vmInterface).AnyTimes()
+
+		kubevirtClient = kubevirtfake.NewSimpleClientset()
+		virtClient.EXPECT().VirtualMachineDiskRestore(diskRestoreTestNamespace).
+			Return(kubevirtClient.SnapshotV1alpha1().VirtualMachineDiskRestores(diskRestoreTestNamespace)).AnyTimes()
+
+		k8sClient = k8sfake.NewSimpleClientset()
+		virtClient.EXPECT().CoreV1().Return(k8sClient.CoreV1()).AnyTimes()
+
+		k8sClient.Fake.PrependReactor("*", "*", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+			Expect(action).To(BeNil())
+			return true, nil, nil
+		})
+		kubevirtClient.Fake.PrependReactor("*", "*", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+			Expect(action).To(BeNil())
+			return true, nil, nil
+		})
+
+		currentTime = diskRestoreTimeFunc
+	})
+
+	AfterEach(func() {
+		close(stop)
+		ctrl.Finish()
+	})
+
+	addDiskRestore := func(dr *VirtualMachineDiskRestore) {
+		syncCaches(stop)
+		mockQueue.ExpectAdds(1)
+		diskRestoreSource.Add(dr)
+		mockQueue.Wait()
+	}
+
+	createDiskSnapshot := func() *VirtualMachineDiskSnapshot {
+		contentName := diskSnapshotName + "-content"
+		return &VirtualMachineDiskSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: diskSnapshotName, Namespace: diskRestoreTestNamespace, UID: "disksnapshot-uid"},
+			Spec:       VirtualMachineDiskSnapshotSpec{Source: corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "source-pvc"}},
+			Status: &VirtualMachineDiskSnapshotStatus{
+				ReadyToUse:                            &t,
+				VirtualMachineDiskSnapshotContentName: &contentName,
+			},
+		}
+	}
+
+	createDiskSnapshotContent := func() *VirtualMachineDiskSnapshotContent {
+		vsName := "vs-" + diskSnapshotName
+		sc := "sc"
+		return &VirtualMachineDiskSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: diskSnapshotName + "-content", Namespace: diskRestoreTestNamespace},
+			Spec: VirtualMachineDiskSnapshotContentSpec{
+				VirtualMachineDiskSnapshotName: &diskSnapshotName,
+				VolumeSnapshotName:             &vsName,
+				SourcePVC: &corev1.PersistentVolumeClaimSpec{
+					AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					StorageClassName: &sc,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")},
+					},
+				},
+			},
+		}
+	}
+
+	createDiskRestore := func() *VirtualMachineDiskRestore {
+		return &VirtualMachineDiskRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: diskRestoreName, Namespace: diskRestoreTestNamespace, UID: "diskrestore-uid"},
+			Spec: VirtualMachineDiskRestoreSpec{
+				VirtualMachineDiskSnapshotName: diskSnapshotName,
+				TargetClaimName:                targetClaimName,
+			},
+		}
+	}
+
+	createDiskRestoreWithOwner := func() *VirtualMachineDiskRestore {
+		dr := createDiskRestore()
+		dr.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion:         snapshotv1.GroupVersion.String(),
+				Kind:               "VirtualMachineDiskSnapshot",
+				Name:               diskSnapshotName,
+				UID:                "disksnapshot-uid",
+				Controller:         &t,
+				BlockOwnerDeletion: &t,
+			},
+		}
+		dr.Status = &VirtualMachineDiskRestoreStatus{Complete: &f}
+		return dr
+	}
+
+	expectDiskRestoreUpdate := func(expected *VirtualMachineDiskRestore) {
+		kubevirtClient.Fake.PrependReactor("update", "virtualmachinediskrestores", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+			update, ok := action.(testing.UpdateAction)
+			Expect(ok).To(BeTrue())
+			updateObj := update.GetObject().(*VirtualMachineDiskRestore)
+			Expect(updateObj).To(Equal(expected))
+			return true, update.GetObject(), nil
+		})
+	}
+
+	expectDiskRestorePVCCreate := func() {
+		k8sClient.Fake.PrependReactor("create", "persistentvolumeclaims", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+			create, ok := action.(testing.CreateAction)
+			Expect(ok).To(BeTrue())
+			createObj := create.GetObject().(*corev1.PersistentVolumeClaim)
+			Expect(createObj.Name).To(Equal(targetClaimName))
+			Expect(createObj.Annotations[diskRestorePVCAnnotation]).To(Equal(diskRestoreName))
+			Expect(createObj.Spec.DataSource.Name).To(Equal("vs-" + diskSnapshotName))
+			return true, create.GetObject(), nil
+		})
+	}
+
+	It("should set an owner reference to its source VirtualMachineDiskSnapshot before creating any PVC", func() {
+		diskSnapshotSource.Add(createDiskSnapshot())
+		diskSnapContentSource.Add(createDiskSnapshotContent())
+
+		dr := createDiskRestore()
+		expected := createDiskRestoreWithOwner()
+		expected.ResourceVersion = "1"
+		expected.Status.Conditions = []snapshotv1.Condition{
+			newReadyCondition(corev1.ConditionFalse, "Initializing VirtualMachineDiskRestore"),
+		}
+		expectDiskRestoreUpdate(expected)
+
+		addDiskRestore(dr)
+		controller.processVMDiskRestoreWorkItem()
+	})
+
+	It("should create the restored PVC pointed at the source VolumeSnapshot", func() {
+		diskSnapshotSource.Add(createDiskSnapshot())
+		diskSnapContentSource.Add(createDiskSnapshotContent())
+		expectDiskRestorePVCCreate()
+
+		dr := createDiskRestoreWithOwner()
+		// Pre-populate the condition reconcile will (re-)set to a freshly
+		// created, not-yet-bound PVC, so the reconcile is a status no-op and
+		// this test only needs to assert on the PVC create call.
+		dr.Status.Conditions = []snapshotv1.Condition{
+			newReadyCondition(corev1.ConditionFalse, "Waiting for restored PVC to be bound"),
+		}
+		addDiskRestore(dr)
+		controller.processVMDiskRestoreWorkItem()
+	})
+
+	It("should block attaching the restored PVC while the target VM has a running VMI", func() {
+		diskSnapshotSource.Add(createDiskSnapshot())
+		diskSnapContentSource.Add(createDiskSnapshotContent())
+
+		dr := createDiskRestoreWithOwner()
+		dr.Spec.TargetVMName = &diskRestoreVMName
+		dr.Spec.VolumeName = "disk1"
+
+		vmSource.Add(&v1.VirtualMachine{
+			ObjectMeta: metav1.ObjectMeta{Name: diskRestoreVMName, Namespace: diskRestoreTestNamespace},
+		})
+		vmiSource.Add(&v1.VirtualMachineInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: diskRestoreVMName, Namespace: diskRestoreTestNamespace},
+		})
+		pvcSource.Add(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: targetClaimName, Namespace: diskRestoreTestNamespace},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		})
+
+		expected := dr.DeepCopy()
+		expected.ResourceVersion = "1"
+		expected.Status.Conditions = []snapshotv1.Condition{
+			newReadyCondition(corev1.ConditionFalse, "Waiting for target VM to be stopped"),
+		}
+		expectDiskRestoreUpdate(expected)
+
+		addDiskRestore(dr)
+		controller.processVMDiskRestoreWorkItem()
+	})
+
+	It("should attach the restored PVC to a stopped target VM and complete", func() {
+		diskSnapshotSource.Add(createDiskSnapshot())
+		diskSnapContentSource.Add(createDiskSnapshotContent())
+
+		dr := createDiskRestoreWithOwner()
+		dr.Spec.TargetVMName = &diskRestoreVMName
+		dr.Spec.VolumeName = "disk1"
+
+		vm := &v1.VirtualMachine{
+			ObjectMeta: metav1.ObjectMeta{Name: diskRestoreVMName, Namespace: diskRestoreTestNamespace},
+			Spec: v1.VirtualMachineSpec{
+				Template: &v1.VirtualMachineInstanceTemplateSpec{Spec: v1.VirtualMachineInstanceSpec{}},
+			},
+		}
+		vmSource.Add(vm)
+		pvcSource.Add(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: targetClaimName, Namespace: diskRestoreTestNamespace},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		})
+
+		updatedVM := vm.DeepCopy()
+		updatedVM.Spec.Template.Spec.Volumes = append(updatedVM.Spec.Template.Spec.Volumes, v1.Volume{
+			Name: "disk1",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: targetClaimName},
+				},
+			},
+		})
+		updatedVM.Spec.Template.Spec.Domain.Devices.Disks = append(updatedVM.Spec.Template.Spec.Domain.Devices.Disks, v1.Disk{
+			Name:       "disk1",
+			DiskDevice: v1.DiskDevice{Disk: &v1.DiskTarget{Bus: v1.DiskBusVirtio}},
+		})
+		vmInterface.EXPECT().Update(gomock.Any(), updatedVM, metav1.UpdateOptions{}).Return(updatedVM, nil)
+
+		expected := dr.DeepCopy()
+		expected.ResourceVersion = "1"
+		expected.Status.PersistentVolumeClaimName = &targetClaimName
+		expected.Status.Complete = &t
+		expected.Status.RestoreTime = currentTime()
+		expected.Status.Conditions = []snapshotv1.Condition{
+			newReadyCondition(corev1.ConditionTrue, "Operation complete"),
+		}
+		expectDiskRestoreUpdate(expected)
+
+		addDiskRestore(dr)
+		controller.processVMDiskRestoreWorkItem()
+	})
+})
@@ -0,0 +1,216 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+)
+
+var validResourceModifierOps = map[string]bool{
+	"replace": true,
+	"add":     true,
+	"remove":  true,
+	"copy":    true,
+}
+
+// resourceModifierSet is VirtualMachineRestoreSpec.ResourceModifiers
+// compiled once -- its name regexes parsed and its rules validated -- so a
+// single restore can reuse it across the target VM and every PVC/
+// DataVolumeTemplate it submits, instead of re-parsing on every call.
+// Modeled after Velero's resource modifier ConfigMaps: each rule scopes a
+// list of RFC 6902 patch operations to objects matching a GroupResource,
+// name and optional condition, rather than addressing one hand-written
+// JSON Patch at the whole VirtualMachine the way Spec.Patches does.
+type resourceModifierSet struct {
+	rules []compiledResourceModifierRule
+}
+
+type compiledResourceModifierRule struct {
+	rule     snapshotv1.ResourceModifierRule
+	nameExpr *regexp.Regexp
+}
+
+// compileResourceModifiers validates and compiles spec once per restore. A
+// nil spec compiles to an empty set whose apply is always a no-op.
+func compileResourceModifiers(spec *snapshotv1.ResourceModifierSpec) (*resourceModifierSet, error) {
+	set := &resourceModifierSet{}
+	if spec == nil {
+		return set, nil
+	}
+
+	for i, rule := range spec.ResourceModifierRules {
+		if rule.Conditions.GroupResource == "" {
+			return nil, fmt.Errorf("resourceModifierRules[%d]: groupResource is required", i)
+		}
+		for j, p := range rule.Patches {
+			if !validResourceModifierOps[p.Operation] {
+				return nil, fmt.Errorf("resourceModifierRules[%d].patches[%d]: unsupported operation %q", i, j, p.Operation)
+			}
+			if !strings.HasPrefix(p.Path, "/") {
+				return nil, fmt.Errorf("resourceModifierRules[%d].patches[%d]: path %q must be RFC 6902 (start with /)", i, j, p.Path)
+			}
+			if p.Operation == "copy" && p.From == "" {
+				return nil, fmt.Errorf("resourceModifierRules[%d].patches[%d]: copy requires from", i, j)
+			}
+		}
+
+		compiled := compiledResourceModifierRule{rule: rule}
+		if rule.Conditions.ResourceNameRegex != "" {
+			expr, err := regexp.Compile(rule.Conditions.ResourceNameRegex)
+			if err != nil {
+				return nil, fmt.Errorf("resourceModifierRules[%d]: %w", i, err)
+			}
+			compiled.nameExpr = expr
+		}
+		set.rules = append(set.rules, compiled)
+	}
+	return set, nil
+}
+
+// apply rewrites obj in place with every rule matching groupResource and
+// name, returning the matched rules' GroupResource+index (for recording on
+// the restore's PatchApplied condition).
+func (set *resourceModifierSet) apply(groupResource, name string, obj interface{}) ([]string, error) {
+	if set == nil || len(set.rules) == 0 {
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for i, compiled := range set.rules {
+		if compiled.rule.Conditions.GroupResource != groupResource {
+			continue
+		}
+		if compiled.nameExpr != nil && !compiled.nameExpr.MatchString(name) {
+			continue
+		}
+
+		var doc interface{}
+		if err := json.Unmarshal(encoded, &doc); err != nil {
+			return nil, err
+		}
+		if compiled.rule.Conditions.Selector != "" {
+			ok, err := evalResourceModifierSelector(doc, compiled.rule.Conditions.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("resourceModifierRules[%d]: %w", i, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		patch, err := encodeResourceModifierPatch(compiled.rule.Patches)
+		if err != nil {
+			return nil, fmt.Errorf("resourceModifierRules[%d]: %w", i, err)
+		}
+		encoded, err = patch.Apply(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("resourceModifierRules[%d]: %w", i, err)
+		}
+		matched = append(matched, fmt.Sprintf("%s[%d]", groupResource, i))
+	}
+
+	if len(matched) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(encoded, obj); err != nil {
+		return nil, err
+	}
+	return matched, nil
+}
+
+// encodeResourceModifierPatch renders patches as an RFC 6902 JSON Patch
+// document so it can go through the same jsonpatch.DecodePatch/Apply path
+// makeVMFromContent already uses for Spec.Patches.
+func encodeResourceModifierPatch(patches []snapshotv1.ResourceModifierPatch) (jsonpatch.Patch, error) {
+	var ops []string
+	for _, p := range patches {
+		switch p.Operation {
+		case "remove":
+			ops = append(ops, fmt.Sprintf(`{"op":"remove","path":%s}`, mustJSONString(p.Path)))
+		case "copy":
+			ops = append(ops, fmt.Sprintf(`{"op":"copy","from":%s,"path":%s}`, mustJSONString(p.From), mustJSONString(p.Path)))
+		default:
+			value := p.Value
+			if value == "" {
+				value = "null"
+			}
+			ops = append(ops, fmt.Sprintf(`{"op":%s,"path":%s,"value":%s}`, mustJSONString(p.Operation), mustJSONString(p.Path), value))
+		}
+	}
+	return jsonpatch.DecodePatch([]byte("[" + strings.Join(ops, ",") + "]"))
+}
+
+func mustJSONString(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
+
+// evalResourceModifierSelector evaluates a "<dotted.path> == <value>"
+// condition against doc (the result of unmarshaling an object's JSON into
+// an interface{}). A path segment that resolves to a []interface{} is
+// satisfied if any element matches the remaining path, so
+// "spec.template.spec.domain.devices.interfaces.name == default" matches a
+// VM with at least one interface named "default".
+func evalResourceModifierSelector(doc interface{}, selector string) (bool, error) {
+	parts := strings.SplitN(selector, "==", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("selector %q must be of the form \"path == value\"", selector)
+	}
+	path := strings.Split(strings.TrimSpace(parts[0]), ".")
+	want := strings.TrimSpace(parts[1])
+	return matchesAtPath(doc, path, want), nil
+}
+
+func matchesAtPath(doc interface{}, path []string, want string) bool {
+	if len(path) == 0 {
+		s, ok := doc.(string)
+		return ok && s == want
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		next, ok := v[path[0]]
+		if !ok {
+			return false
+		}
+		return matchesAtPath(next, path[1:], want)
+	case []interface{}:
+		for _, elem := range v {
+			if matchesAtPath(elem, path, want) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
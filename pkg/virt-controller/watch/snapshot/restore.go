@@ -0,0 +1,1816 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+// Package snapshot implements the controllers backing the snapshot/restore
+// subsystem: VirtualMachineSnapshot (and its VirtualMachineSnapshotContent)
+// capture a VM's spec and PVCs via CSI VolumeSnapshots, and
+// VirtualMachineRestore (VMRestoreController, this file) replays that
+// capture back onto a target VM.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	vsv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/pointer"
+
+	v1 "kubevirt.io/api/core/v1"
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+	"kubevirt.io/client-go/kubecli"
+	"kubevirt.io/client-go/log"
+
+	"kubevirt.io/kubevirt/pkg/util/status"
+)
+
+const (
+	// lastRestoreAnnotation records, on a restore target VM, the restoreID
+	// (see restoreID) of the VirtualMachineRestore that most recently
+	// rewrote its DataVolumeTemplates and Volumes to point at restored
+	// disks. It lets the controller tell whether a previously issued VM
+	// spec update has already landed.
+	lastRestoreAnnotation = "restore.kubevirt.io/lastRestoreUID"
+
+	// restorePVCAnnotation marks a PVC materialized by the restore
+	// controller as belonging to a given VirtualMachineRestore, so the PVC
+	// informer's event handler knows which restore to requeue when it
+	// changes.
+	restorePVCAnnotation = "restore.kubevirt.io/name"
+
+	// populatedForPVCAnnotation is the CDI annotation that adopts an
+	// already-populated PVC as the backing volume of a DataVolume of the
+	// same name, instead of having CDI import or clone into it again.
+	populatedForPVCAnnotation = "cdi.kubevirt.io/storage.populatedFor"
+
+	// warmupJobAnnotation marks a Job created by the post-restore volume
+	// warmup step as belonging to a given VirtualMachineRestore, so the Job
+	// informer's event handler knows which restore to requeue when it
+	// changes.
+	warmupJobAnnotation = "restore.kubevirt.io/name"
+
+	// requesterAnnotation records the username of whoever created the
+	// VirtualMachineRestore, stamped on by the validating webhook from the
+	// admission request's UserInfo. A cross-namespace restore -- one whose
+	// Spec.Target.Namespace differs from the restore's own namespace -- uses
+	// it to re-check, via SubjectAccessReview, that the requester can create
+	// VirtualMachines/PVCs in the target namespace: RBAC on the
+	// VirtualMachineRestore object itself says nothing about rights there.
+	requesterAnnotation = "restore.kubevirt.io/requester"
+
+	// vmRestoreFinalizer blocks deletion of a VirtualMachineRestore whose
+	// create phase has completed until the vm-restore-finalizer controller
+	// (see restorefinalizer.go) has patched every restored PV's
+	// reclaimPolicy/nodeAffinity/volumeAttributes/labels/annotations back to
+	// their source values, mirroring Velero's restore-finalizer pattern.
+	vmRestoreFinalizer = "snapshot.kubevirt.io/vmrestore-protection"
+)
+
+// defaultWarmupImage runs a restored volume's warmup Job when
+// VirtualMachineRestore.Spec.Warmup.Image is unset.
+var defaultWarmupImage = "busybox"
+
+// t and f back the many *bool fields VirtualMachineRestore/VirtualMachine
+// status and owner references use (Controller, BlockOwnerDeletion,
+// Complete, ReadyToUse, ...).
+var (
+	t = true
+	f = false
+)
+
+// currentTime is a seam for tests: production code always calls it as
+// metav1.Now(), but tests substitute a fixed clock for deterministic
+// condition/time-stamp assertions.
+var currentTime = func() *metav1.Time {
+	now := metav1.Now()
+	return &now
+}
+
+func newProgressingCondition(status corev1.ConditionStatus, reason string) snapshotv1.Condition {
+	return snapshotv1.Condition{
+		Type:               snapshotv1.ConditionProgressing,
+		Status:             status,
+		Reason:             reason,
+		LastTransitionTime: *currentTime(),
+	}
+}
+
+func newReadyCondition(status corev1.ConditionStatus, reason string) snapshotv1.Condition {
+	return snapshotv1.Condition{
+		Type:               snapshotv1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		LastTransitionTime: *currentTime(),
+	}
+}
+
+// stickyRestoreConditions are condition types owned by a process other than
+// updateVMRestore's own progressing/ready reporting -- setRestoreConditions
+// runs on every reconcile tick and would otherwise wipe them out.
+var stickyRestoreConditions = []snapshotv1.ConditionType{
+	snapshotv1.ConditionPatchApplied,
+	snapshotv1.ConditionFinalized,
+}
+
+func setRestoreConditions(vmRestore *snapshotv1.VirtualMachineRestore, progressingStatus corev1.ConditionStatus, progressingReason string, readyStatus corev1.ConditionStatus, readyReason string) {
+	conditions := []snapshotv1.Condition{
+		newProgressingCondition(progressingStatus, progressingReason),
+		newReadyCondition(readyStatus, readyReason),
+	}
+	for _, conditionType := range stickyRestoreConditions {
+		if existing := getRestoreCondition(vmRestore, conditionType); existing != nil {
+			conditions = append(conditions, *existing)
+		}
+	}
+	vmRestore.Status.Conditions = conditions
+}
+
+func getRestoreCondition(vmRestore *snapshotv1.VirtualMachineRestore, conditionType snapshotv1.ConditionType) *snapshotv1.Condition {
+	for i := range vmRestore.Status.Conditions {
+		if vmRestore.Status.Conditions[i].Type == conditionType {
+			return &vmRestore.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setRestoreCondition upserts condition by Type, leaving every other
+// condition (including the progressing/ready pair) untouched.
+func setRestoreCondition(vmRestore *snapshotv1.VirtualMachineRestore, condition snapshotv1.Condition) {
+	if existing := getRestoreCondition(vmRestore, condition.Type); existing != nil {
+		*existing = condition
+		return
+	}
+	vmRestore.Status.Conditions = append(vmRestore.Status.Conditions, condition)
+}
+
+func newPatchAppliedCondition(status corev1.ConditionStatus, reason string) snapshotv1.Condition {
+	return snapshotv1.Condition{
+		Type:               snapshotv1.ConditionPatchApplied,
+		Status:             status,
+		Reason:             reason,
+		LastTransitionTime: *currentTime(),
+	}
+}
+
+// setPatchAppliedCondition records, once per restore, which resource
+// modifier rules (if any) matched while building the restore target --
+// letting users confirm a StorageClass retarget or interface rename they
+// configured actually fired, instead of silently being a no-op because a
+// groupResource or selector typo never matched anything.
+func setPatchAppliedCondition(vmRestore *snapshotv1.VirtualMachineRestore, matched []string) {
+	reason := "No resource modifier rules matched"
+	status := corev1.ConditionFalse
+	if len(matched) > 0 {
+		status = corev1.ConditionTrue
+		reason = strings.Join(matched, ",")
+	}
+	setRestoreCondition(vmRestore, newPatchAppliedCondition(status, reason))
+}
+
+// restoreID uniquely names the artifacts (PVCs, DataVolumes, the
+// lastRestoreAnnotation value) a single VirtualMachineRestore produces.
+func restoreID(vmRestore *snapshotv1.VirtualMachineRestore) string {
+	return fmt.Sprintf("%s-%s", vmRestore.Name, vmRestore.UID)
+}
+
+// restoreDVName is the name given to the PVC/DataVolume a restore
+// materializes to hold volumeName's restored contents.
+func restoreDVName(vmRestore *snapshotv1.VirtualMachineRestore, volumeName string) string {
+	return fmt.Sprintf("%s-%s", restoreID(vmRestore), volumeName)
+}
+
+func controllerKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// targetNamespace is where a restore's VM and PVCs land: vmRestore.Namespace,
+// unless Spec.Target.Namespace names a different one for a cross-namespace
+// restore. The VirtualMachineSnapshot/Content being restored from always
+// stays put in vmRestore.Namespace -- only the target moves.
+func targetNamespace(vmRestore *snapshotv1.VirtualMachineRestore) string {
+	if vmRestore.Spec.Target.Namespace != nil && *vmRestore.Spec.Target.Namespace != "" {
+		return *vmRestore.Spec.Target.Namespace
+	}
+	return vmRestore.Namespace
+}
+
+// validateTargetNamespaceAccess re-checks, for a cross-namespace restore,
+// that the requester (requesterAnnotation) can create VirtualMachines and
+// PersistentVolumeClaims in the target namespace. RBAC granted on the
+// VirtualMachineRestore object says nothing about the requester's rights in
+// a namespace that is not its own, so this closes a privilege-escalation gap
+// where restoring into an arbitrary namespace would otherwise bypass it.
+func (ctrl *VMRestoreController) validateTargetNamespaceAccess(vmRestore *snapshotv1.VirtualMachineRestore) error {
+	ns := targetNamespace(vmRestore)
+	if ns == vmRestore.Namespace {
+		return nil
+	}
+
+	username, ok := vmRestore.Annotations[requesterAnnotation]
+	if !ok {
+		return nil
+	}
+
+	checks := []authorizationv1.ResourceAttributes{
+		{Namespace: ns, Verb: "create", Group: v1.GroupVersion.Group, Resource: "virtualmachines"},
+		{Namespace: ns, Verb: "create", Resource: "persistentvolumeclaims"},
+	}
+	for _, attrs := range checks {
+		attrs := attrs
+		sar := &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:               username,
+				ResourceAttributes: &attrs,
+			},
+		}
+		result, err := ctrl.Client.AuthorizationV1().SubjectAccessReviews().Create(context.Background(), sar, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		if !result.Status.Allowed {
+			return fmt.Errorf("user %s may not %s %s in namespace %s", username, attrs.Verb, attrs.Resource, ns)
+		}
+	}
+	return nil
+}
+
+// VolumeSnapshotProvider looks up the CSI VolumeSnapshot backing a restored
+// disk, and creates the namespaced VolumeSnapshot a cross-namespace restore
+// clones it into (see reconcileCrossNamespaceSnapshot). It exists so the
+// restore controller does not need a direct dependency on a snapshotter
+// clientset, and so tests can substitute a fake.
+type VolumeSnapshotProvider interface {
+	GetVolumeSnapshot(namespace, name string) (*vsv1.VolumeSnapshot, error)
+	CreateVolumeSnapshot(namespace string, vs *vsv1.VolumeSnapshot) (*vsv1.VolumeSnapshot, error)
+	// GetVolumeSnapshotContent resolves vsName's VolumeSnapshotContent,
+	// following the bind the way GetVolumeSnapshot(namespace,
+	// vsName).Status.BoundVolumeSnapshotContentName would, except it also
+	// reports "not yet bound" as a nil, no-error return instead of requiring
+	// the caller to do that lookup itself. Used to gate PVC creation on
+	// snapshotHandleReady.
+	GetVolumeSnapshotContent(namespace, vsName string) (*vsv1.VolumeSnapshotContent, error)
+}
+
+// VMRestoreController replays a VirtualMachineSnapshot back onto a target
+// VirtualMachine: it (re)creates the snapshotted PVCs from their
+// VolumeSnapshots, waits for the target to be safe to update, then rewrites
+// the target's DataVolumeTemplates and Volumes to point at the restored
+// disks.
+type VMRestoreController struct {
+	Client                    kubecli.KubevirtClient
+	VMRestoreInformer         cache.SharedIndexInformer
+	VMSnapshotInformer        cache.SharedIndexInformer
+	VMSnapshotContentInformer cache.SharedIndexInformer
+	VMInformer                cache.SharedIndexInformer
+	VMIInformer               cache.SharedIndexInformer
+	PVCInformer               cache.SharedIndexInformer
+	StorageClassInformer      cache.SharedIndexInformer
+	DataVolumeInformer        cache.SharedIndexInformer
+	JobInformer               cache.SharedIndexInformer
+	// VMSnapshotImportInformer backs the DR/migration restore flow
+	// (Spec.Source referencing a VirtualMachineSnapshotExport, possibly in a
+	// remote cluster): it lets the controller tell whether it has already
+	// created the VirtualMachineSnapshotImport that downloads that export's
+	// volumes, see datamover.go.
+	VMSnapshotImportInformer    cache.SharedIndexInformer
+	Recorder                    record.EventRecorder
+	VolumeSnapshotProvider      VolumeSnapshotProvider
+	VolumeSnapshotContentReader VolumeSnapshotContentReader
+
+	vmStatusUpdater *status.VMStatusUpdater
+	vmRestoreQueue  workqueue.RateLimitingInterface
+}
+
+// Init wires up the controller's workqueue and informer event handlers. It
+// must be called once before Run/processVMRestoreWorkItem.
+func (ctrl *VMRestoreController) Init() {
+	ctrl.vmRestoreQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "virt-controller-vm-restore")
+	if ctrl.vmStatusUpdater == nil {
+		ctrl.vmStatusUpdater = status.NewVMStatusUpdater(ctrl.Client)
+	}
+
+	ctrl.VMRestoreInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueVMRestore,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.enqueueVMRestore(newObj) },
+	})
+
+	ctrl.VMInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleVM,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handleVM(newObj) },
+	})
+
+	ctrl.PVCInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handlePVC,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handlePVC(newObj) },
+	})
+
+	ctrl.JobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handleWarmupJob,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handleWarmupJob(newObj) },
+	})
+}
+
+func (ctrl *VMRestoreController) enqueueVMRestore(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	ctrl.vmRestoreQueue.Add(key)
+}
+
+func (ctrl *VMRestoreController) handleVM(obj interface{}) {
+	vm, ok := obj.(*v1.VirtualMachine)
+	if !ok || vm.Status.RestoreInProgress == nil {
+		return
+	}
+	ctrl.vmRestoreQueue.Add(controllerKey(vm.Namespace, *vm.Status.RestoreInProgress))
+}
+
+func (ctrl *VMRestoreController) handlePVC(obj interface{}) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return
+	}
+	name, ok := pvc.Annotations[restorePVCAnnotation]
+	if !ok {
+		return
+	}
+	ctrl.vmRestoreQueue.Add(controllerKey(pvc.Namespace, name))
+}
+
+func (ctrl *VMRestoreController) handleWarmupJob(obj interface{}) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	name, ok := job.Annotations[warmupJobAnnotation]
+	if !ok {
+		return
+	}
+	ctrl.vmRestoreQueue.Add(controllerKey(job.Namespace, name))
+}
+
+// Run starts the controller's worker loop and blocks until stopCh is closed.
+func (ctrl *VMRestoreController) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer ctrl.vmRestoreQueue.ShutDown()
+
+	log.Log.Info("Starting restore controller.")
+	defer log.Log.Info("Shutting down restore controller.")
+
+	if !cache.WaitForCacheSync(
+		stopCh,
+		ctrl.VMRestoreInformer.HasSynced,
+		ctrl.VMSnapshotInformer.HasSynced,
+		ctrl.VMSnapshotContentInformer.HasSynced,
+		ctrl.VMInformer.HasSynced,
+		ctrl.VMIInformer.HasSynced,
+		ctrl.PVCInformer.HasSynced,
+		ctrl.StorageClassInformer.HasSynced,
+		ctrl.DataVolumeInformer.HasSynced,
+		ctrl.JobInformer.HasSynced,
+		ctrl.VMSnapshotImportInformer.HasSynced,
+	) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < threadiness; i++ {
+		go ctrl.worker()
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (ctrl *VMRestoreController) worker() {
+	for ctrl.processVMRestoreWorkItem() {
+	}
+}
+
+func (ctrl *VMRestoreController) processVMRestoreWorkItem() bool {
+	key, quit := ctrl.vmRestoreQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.vmRestoreQueue.Done(key)
+
+	if err := ctrl.updateVMRestore(key.(string)); err != nil {
+		log.Log.Reason(err).Errorf("error updating VirtualMachineRestore %s", key)
+		ctrl.vmRestoreQueue.AddRateLimited(key)
+		return true
+	}
+
+	ctrl.vmRestoreQueue.Forget(key)
+	return true
+}
+
+func (ctrl *VMRestoreController) updateVMRestore(key string) error {
+	obj, exists, err := ctrl.VMRestoreInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+
+	original := obj.(*snapshotv1.VirtualMachineRestore)
+	if original.DeletionTimestamp != nil {
+		if original.Status == nil {
+			return nil
+		}
+		return ctrl.cleanupWarmupJobs(original)
+	}
+
+	vmRestore := original.DeepCopy()
+	if vmRestore.Status == nil {
+		vmRestore.Status = &snapshotv1.VirtualMachineRestoreStatus{Complete: pointer.Bool(false)}
+	}
+
+	target, err := ctrl.getTarget(vmRestore)
+	if err != nil {
+		return err
+	}
+
+	if err := ctrl.validateTargetNamespaceAccess(vmRestore); err != nil {
+		ctrl.Recorder.Eventf(vmRestore, corev1.EventTypeWarning, "VirtualMachineRestoreError", err.Error())
+		setRestoreConditions(vmRestore, corev1.ConditionFalse, err.Error(), corev1.ConditionFalse, err.Error())
+		if uerr := ctrl.updateVMRestoreStatus(original, vmRestore); uerr != nil {
+			return uerr
+		}
+		return err
+	}
+
+	if !target.Exists() {
+		if _, err := target.Reconcile(); err != nil {
+			return err
+		}
+	}
+
+	if err := target.UpdateRestoreInProgress(); err != nil {
+		return err
+	}
+
+	if vmRestore.Status.Complete != nil && *vmRestore.Status.Complete {
+		return target.UpdateDoneRestore()
+	}
+
+	// A Spec.Source-driven restore (see reconcileImportedRestorePVCs) gets its
+	// volumes from a VirtualMachineSnapshotImport rather than an in-cluster
+	// VirtualMachineSnapshotContent, e.g. when the export it downloads from
+	// lives in a different cluster entirely.
+	var content *snapshotv1.VirtualMachineSnapshotContent
+	if vmRestore.Spec.Source == nil {
+		content, err = ctrl.getSnapshotContent(vmRestore)
+		if err != nil {
+			ctrl.Recorder.Eventf(vmRestore, corev1.EventTypeWarning, "VirtualMachineRestoreError", err.Error())
+			setRestoreConditions(vmRestore, corev1.ConditionFalse, err.Error(), corev1.ConditionFalse, err.Error())
+			if uerr := ctrl.updateVMRestoreStatus(original, vmRestore); uerr != nil {
+				return uerr
+			}
+			return err
+		}
+	}
+
+	if len(vmRestore.OwnerReferences) == 0 {
+		vmRestore.OwnerReferences = []metav1.OwnerReference{target.OwnerReference()}
+		setRestoreConditions(vmRestore,
+			corev1.ConditionTrue, "Initializing VirtualMachineRestore",
+			corev1.ConditionFalse, "Initializing VirtualMachineRestore")
+		return ctrl.updateVMRestoreStatus(original, vmRestore)
+	}
+
+	if len(vmRestore.Status.Restores) == 0 {
+		if vmRestore.Spec.Source != nil {
+			imp, err := ctrl.getSnapshotImport(vmRestore)
+			if err != nil {
+				ctrl.Recorder.Eventf(vmRestore, corev1.EventTypeWarning, "VirtualMachineRestoreError", err.Error())
+				setRestoreConditions(vmRestore, corev1.ConditionFalse, err.Error(), corev1.ConditionFalse, err.Error())
+				if uerr := ctrl.updateVMRestoreStatus(original, vmRestore); uerr != nil {
+					return uerr
+				}
+				return err
+			}
+			vmRestore.Status.Restores = initializeImportedVolumeRestores(imp)
+		} else {
+			vmRestore.Status.Restores = initializeVolumeRestores(vmRestore, content)
+		}
+		setRestoreConditions(vmRestore,
+			corev1.ConditionTrue, "Creating new PVCs",
+			corev1.ConditionFalse, "Waiting for new PVCs")
+		return ctrl.updateVMRestoreStatus(original, vmRestore)
+	}
+
+	var pvcsBound bool
+	if vmRestore.Spec.Source != nil {
+		pvcsBound, err = ctrl.reconcileImportedRestorePVCs(vmRestore)
+	} else {
+		pvcsBound, err = ctrl.reconcileVolumeRestorePVCs(vmRestore, content)
+	}
+	if err != nil {
+		ctrl.Recorder.Eventf(vmRestore, corev1.EventTypeWarning, "VirtualMachineRestoreError", err.Error())
+		setRestoreConditions(vmRestore, corev1.ConditionFalse, err.Error(), corev1.ConditionFalse, err.Error())
+		if uerr := ctrl.updateVMRestoreStatus(original, vmRestore); uerr != nil {
+			return uerr
+		}
+		return err
+	}
+	if !pvcsBound {
+		return ctrl.updateVMRestoreStatus(original, vmRestore)
+	}
+
+	targetHasVMI, err := ctrl.targetVMIExists(target.VM())
+	if err != nil {
+		return err
+	}
+	if targetHasVMI {
+		setRestoreConditions(vmRestore,
+			corev1.ConditionFalse, "Waiting for target to be ready",
+			corev1.ConditionFalse, "Waiting for target to be ready")
+		return ctrl.updateVMRestoreStatus(original, vmRestore)
+	}
+
+	allReady, err := ctrl.markVolumeRestoresReady(vmRestore)
+	if err != nil {
+		return err
+	}
+	if !allReady {
+		return ctrl.updateVMRestoreStatus(original, vmRestore)
+	}
+
+	warmupDone, err := ctrl.reconcileWarmup(vmRestore)
+	if err != nil {
+		return err
+	}
+	if !warmupDone {
+		setRestoreConditions(vmRestore,
+			corev1.ConditionTrue, "Warming up restored volumes",
+			corev1.ConditionFalse, "Waiting for volume warmup")
+		return ctrl.updateVMRestoreStatus(original, vmRestore)
+	}
+
+	if vmRestore.Status.DeletedDataVolumes == nil {
+		vmRestore.Status.DeletedDataVolumes = dataVolumeTemplateNames(target.VM())
+	}
+	setRestoreConditions(vmRestore,
+		corev1.ConditionTrue, "Updating target spec",
+		corev1.ConditionFalse, "Waiting for target update")
+
+	updated, err := target.UpdateTarget(vmRestore)
+	if err != nil {
+		return err
+	}
+	if updated {
+		return ctrl.updateVMRestoreStatus(original, vmRestore)
+	}
+
+	if err := ctrl.deleteDataVolumes(targetNamespace(vmRestore), vmRestore.Status.DeletedDataVolumes); err != nil {
+		return err
+	}
+	vmRestore.Status.Complete = pointer.Bool(true)
+	vmRestore.Status.RestoreTime = currentTime()
+	if !hasFinalizer(vmRestore.Finalizers, vmRestoreFinalizer) {
+		vmRestore.Finalizers = append(vmRestore.Finalizers, vmRestoreFinalizer)
+	}
+	setRestoreConditions(vmRestore,
+		corev1.ConditionFalse, "Operation complete",
+		corev1.ConditionTrue, "Operation complete")
+	ctrl.Recorder.Eventf(vmRestore, corev1.EventTypeNormal, "VirtualMachineRestoreComplete", "Successfully completed VirtualMachineRestore %s", vmRestore.Name)
+	return ctrl.updateVMRestoreStatus(original, vmRestore)
+}
+
+// warmupJobName is the name given to the Job that pre-warms the PVC restored
+// for vr by sequentially reading it, so lazily-hydrated CSI snapshots (e.g.
+// EBS/GCE-PD restores) don't pay their first-access latency cliff inside the
+// guest.
+func warmupJobName(vmRestore *snapshotv1.VirtualMachineRestore, volumeName string) string {
+	return fmt.Sprintf("%s-warmup-%s", restoreID(vmRestore), volumeName)
+}
+
+// reconcileWarmup drives the optional post-restore volume warmup step
+// (VirtualMachineRestore.Spec.Warmup): for every restored volume not yet
+// warmed it ensures a warmup Job exists and reflects its progress in
+// Status.WarmupStatus. It reports whether the restore may proceed past
+// warmup: always true when warmup is off, true once every Job has been
+// launched for WarmupAsync (warmup then continues in the background), and
+// true only once every Job has succeeded for WarmupSync.
+func (ctrl *VMRestoreController) reconcileWarmup(vmRestore *snapshotv1.VirtualMachineRestore) (bool, error) {
+	mode := snapshotv1.VirtualMachineRestoreWarmupOff
+	if vmRestore.Spec.Warmup != nil {
+		mode = vmRestore.Spec.Warmup.Mode
+	}
+	if mode == snapshotv1.VirtualMachineRestoreWarmupOff {
+		return true, nil
+	}
+
+	statusByVolume := make(map[string]*snapshotv1.VolumeWarmupStatus, len(vmRestore.Status.WarmupStatus))
+	for i := range vmRestore.Status.WarmupStatus {
+		statusByVolume[vmRestore.Status.WarmupStatus[i].VolumeName] = &vmRestore.Status.WarmupStatus[i]
+	}
+
+	allSucceeded := true
+	for _, vr := range vmRestore.Status.Restores {
+		volumeStatus := statusByVolume[vr.VolumeName]
+		if volumeStatus == nil {
+			vmRestore.Status.WarmupStatus = append(vmRestore.Status.WarmupStatus, snapshotv1.VolumeWarmupStatus{
+				VolumeName: vr.VolumeName,
+				Phase:      snapshotv1.VolumeWarmupPhasePending,
+			})
+			volumeStatus = &vmRestore.Status.WarmupStatus[len(vmRestore.Status.WarmupStatus)-1]
+		}
+
+		if volumeStatus.Phase == snapshotv1.VolumeWarmupPhaseSucceeded {
+			continue
+		}
+
+		job, err := ctrl.ensureWarmupJob(vmRestore, vr)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case jobSucceeded(job):
+			volumeStatus.Phase = snapshotv1.VolumeWarmupPhaseSucceeded
+			volumeStatus.CompletionTime = currentTime()
+		case jobFailed(job):
+			volumeStatus.Phase = snapshotv1.VolumeWarmupPhaseFailed
+			allSucceeded = false
+			ctrl.Recorder.Eventf(vmRestore, corev1.EventTypeWarning, "VirtualMachineRestoreWarmupFailed",
+				"warmup job for volume %s exhausted its retries and will be recreated", vr.VolumeName)
+			if derr := ctrl.Client.BatchV1().Jobs(targetNamespace(vmRestore)).Delete(context.Background(), job.Name, metav1.DeleteOptions{}); derr != nil && !errors.IsNotFound(derr) {
+				return false, derr
+			}
+		default:
+			volumeStatus.Phase = snapshotv1.VolumeWarmupPhaseRunning
+			allSucceeded = false
+		}
+	}
+
+	if mode == snapshotv1.VirtualMachineRestoreWarmupAsync {
+		return true, nil
+	}
+	return allSucceeded, nil
+}
+
+func jobSucceeded(job *batchv1.Job) bool {
+	return job != nil && job.Status.Succeeded > 0
+}
+
+func jobFailed(job *batchv1.Job) bool {
+	if job == nil || job.Spec.BackoffLimit == nil {
+		return false
+	}
+	return job.Status.Failed > *job.Spec.BackoffLimit
+}
+
+// ensureWarmupJob creates the Job that pre-warms vr's restored PVC by
+// sequentially reading it raw-block, if one does not already exist.
+func (ctrl *VMRestoreController) ensureWarmupJob(vmRestore *snapshotv1.VirtualMachineRestore, vr snapshotv1.VolumeRestore) (*batchv1.Job, error) {
+	name := warmupJobName(vmRestore, vr.VolumeName)
+	key := controllerKey(targetNamespace(vmRestore), name)
+	obj, exists, err := ctrl.JobInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return obj.(*batchv1.Job), nil
+	}
+
+	image := defaultWarmupImage
+	if vmRestore.Spec.Warmup.Image != "" {
+		image = vmRestore.Spec.Warmup.Image
+	}
+
+	backoffLimit := int32(3)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: targetNamespace(vmRestore),
+			Annotations: map[string]string{
+				warmupJobAnnotation: vmRestore.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{warmupJobAnnotation: vmRestore.Name},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "warmup",
+							Image:   image,
+							Command: []string{"sh", "-c", "dd if=/dev/warmup-volume of=/dev/null bs=1M"},
+							VolumeDevices: []corev1.VolumeDevice{
+								{Name: "warmup-volume", DevicePath: "/dev/warmup-volume"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "warmup-volume",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: vr.PersistentVolumeClaimName,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	created, err := ctrl.Client.BatchV1().Jobs(targetNamespace(vmRestore)).Create(context.Background(), job, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	if err != nil {
+		return job, nil
+	}
+	return created, nil
+}
+
+// cleanupWarmupJobs deletes any warmup Jobs a deleted VirtualMachineRestore
+// left behind, since they carry no OwnerReference to it and so are not
+// garbage-collected along with it.
+func (ctrl *VMRestoreController) cleanupWarmupJobs(vmRestore *snapshotv1.VirtualMachineRestore) error {
+	for _, vr := range vmRestore.Status.Restores {
+		name := warmupJobName(vmRestore, vr.VolumeName)
+		if err := ctrl.Client.BatchV1().Jobs(targetNamespace(vmRestore)).Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ctrl *VMRestoreController) targetVMIExists(vm *v1.VirtualMachine) (bool, error) {
+	if vm == nil {
+		return false, nil
+	}
+	_, exists, err := ctrl.VMIInformer.GetStore().GetByKey(controllerKey(vm.Namespace, vm.Name))
+	return exists, err
+}
+
+func (ctrl *VMRestoreController) updateVMRestoreStatus(original, updated *snapshotv1.VirtualMachineRestore) error {
+	if equality.Semantic.DeepEqual(original, updated) {
+		return nil
+	}
+	_, err := ctrl.Client.VirtualMachineRestore(updated.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	return err
+}
+
+func (ctrl *VMRestoreController) getSnapshotContent(vmRestore *snapshotv1.VirtualMachineRestore) (*snapshotv1.VirtualMachineSnapshotContent, error) {
+	return resolveVMSnapshotContent(ctrl.VMSnapshotInformer, ctrl.VMSnapshotContentInformer, vmRestore.Namespace, vmRestore.Spec.VirtualMachineSnapshotName)
+}
+
+// resolveVMSnapshotContent looks up a VirtualMachineSnapshot by name and
+// returns the VirtualMachineSnapshotContent it produced. Shared by
+// VMRestoreController.getSnapshotContent and the disk-restore controller's
+// whole-VM-snapshot source (see diskrestore.go), both of which restore
+// individual volumes out of the same VolumeBackups list.
+func resolveVMSnapshotContent(vmSnapshotInformer, vmSnapshotContentInformer cache.SharedIndexInformer, namespace, snapshotName string) (*snapshotv1.VirtualMachineSnapshotContent, error) {
+	key := controllerKey(namespace, snapshotName)
+	obj, exists, err := vmSnapshotInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VMSnapshot %s does not exist", key)
+	}
+
+	vmSnapshot := obj.(*snapshotv1.VirtualMachineSnapshot)
+	if vmSnapshot.Status == nil || vmSnapshot.Status.VirtualMachineSnapshotContentName == nil {
+		return nil, fmt.Errorf("VMSnapshot %s is not ready", key)
+	}
+
+	contentKey := controllerKey(namespace, *vmSnapshot.Status.VirtualMachineSnapshotContentName)
+	obj, exists, err = vmSnapshotContentInformer.GetStore().GetByKey(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VirtualMachineSnapshotContent %s does not exist", contentKey)
+	}
+
+	return obj.(*snapshotv1.VirtualMachineSnapshotContent).DeepCopy(), nil
+}
+
+// getSnapshotImport resolves a Spec.Source-driven restore's
+// VirtualMachineSnapshotImport (see datamover.go): the data-mover object
+// downloading the volumes of a VirtualMachineSnapshotExport that may live in
+// an entirely different cluster. It is the DR/migration counterpart of
+// getSnapshotContent, and is looked up the same way Spec.Target is.
+func (ctrl *VMRestoreController) getSnapshotImport(vmRestore *snapshotv1.VirtualMachineRestore) (*VirtualMachineSnapshotImport, error) {
+	key := controllerKey(vmRestore.Namespace, vmRestore.Spec.Source.Name)
+	obj, exists, err := ctrl.VMSnapshotImportInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("VirtualMachineSnapshotImport %s does not exist", key)
+	}
+	return obj.(*VirtualMachineSnapshotImport).DeepCopy(), nil
+}
+
+func (ctrl *VMRestoreController) reconcileVolumeRestorePVCs(vmRestore *snapshotv1.VirtualMachineRestore, content *snapshotv1.VirtualMachineSnapshotContent) (bool, error) {
+	modifiers, err := compileResourceModifiers(vmRestore.Spec.ResourceModifiers)
+	if err != nil {
+		return false, err
+	}
+
+	allBound := true
+	var patchMatched []string
+	for _, vr := range vmRestore.Status.Restores {
+		key := controllerKey(targetNamespace(vmRestore), vr.PersistentVolumeClaimName)
+		obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(key)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			allBound = false
+
+			backup := findVolumeBackup(content, vr.VolumeName)
+			if backup == nil {
+				return false, fmt.Errorf("no VolumeBackup for volume %s in %s", vr.VolumeName, content.Name)
+			}
+			override := findVolumeRestoreOverride(vmRestore, vr.VolumeName)
+			if err := ctrl.validateVolumeRestoreOverride(backup, override); err != nil {
+				return false, err
+			}
+
+			// A volume restored from a non-CSI populator (vr.DataSourceRef)
+			// has no VolumeSnapshot/VolumeSnapshotContent to wait on or
+			// validate -- makeRestorePVC points the PVC straight at it.
+			if vr.VolumeSnapshotName == "" {
+				pvc, matched, err := ctrl.makeRestorePVC(vmRestore, vr, backup, override, "", modifiers)
+				if err != nil {
+					return false, err
+				}
+				patchMatched = append(patchMatched, matched...)
+				if _, err := ctrl.Client.CoreV1().PersistentVolumeClaims(targetNamespace(vmRestore)).Create(context.Background(), pvc, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+					return false, err
+				}
+				recordVolumeRestorePVCCreated(vmRestore, vr, pvc, restoreMethodPopulator, "")
+				continue
+			}
+
+			handleReady, err := ctrl.snapshotHandleReady(vmRestore.Namespace, vr.VolumeSnapshotName)
+			if err != nil {
+				return false, err
+			}
+			if !handleReady {
+				setRestoreConditions(vmRestore,
+					corev1.ConditionFalse, "WaitingForSnapshotHandle",
+					corev1.ConditionFalse, "WaitingForSnapshotHandle")
+				continue
+			}
+
+			ready, err := ctrl.validateSnapshotPreflight(vmRestore, backup, override, vr.VolumeSnapshotName)
+			if err != nil {
+				return false, err
+			}
+			if !ready {
+				continue
+			}
+
+			volumeSnapshotName, ready, err := ctrl.reconcileCrossNamespaceSnapshot(vmRestore, vr.VolumeName, vr.VolumeSnapshotName)
+			if err != nil {
+				return false, err
+			}
+			if !ready {
+				continue
+			}
+
+			pvc, matched, err := ctrl.makeRestorePVC(vmRestore, vr, backup, override, volumeSnapshotName, modifiers)
+			if err != nil {
+				return false, err
+			}
+			patchMatched = append(patchMatched, matched...)
+			if _, err := ctrl.Client.CoreV1().PersistentVolumeClaims(targetNamespace(vmRestore)).Create(context.Background(), pvc, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+				return false, err
+			}
+
+			driver := ""
+			if vsc, err := ctrl.VolumeSnapshotProvider.GetVolumeSnapshotContent(vmRestore.Namespace, volumeSnapshotName); err == nil && vsc != nil {
+				driver = vsc.Spec.Driver
+			}
+			recordVolumeRestorePVCCreated(vmRestore, vr, pvc, restoreMethodCSISnapshot, driver)
+			continue
+		}
+
+		pvc := obj.(*corev1.PersistentVolumeClaim)
+		if pvc.Status.Phase != corev1.ClaimBound {
+			allBound = false
+		}
+	}
+	if len(patchMatched) > 0 {
+		setPatchAppliedCondition(vmRestore, patchMatched)
+	}
+	return allBound, nil
+}
+
+// reconcileImportedRestorePVCs is reconcileVolumeRestorePVCs's counterpart
+// for a Spec.Source-driven restore: rather than materializing PVCs from
+// VolumeBackups, it waits for the VirtualMachineSnapshotImport (already
+// downloading each volume from its VirtualMachineSnapshotExport, possibly in
+// a remote cluster) to report a PVC ready, and adopts that PVC's name as-is.
+func (ctrl *VMRestoreController) reconcileImportedRestorePVCs(vmRestore *snapshotv1.VirtualMachineRestore) (bool, error) {
+	imp, err := ctrl.getSnapshotImport(vmRestore)
+	if err != nil {
+		return false, err
+	}
+	if imp.Status == nil {
+		return false, nil
+	}
+
+	statusByVolume := make(map[string]VolumeImportStatus, len(imp.Status.VolumeImports))
+	for _, vi := range imp.Status.VolumeImports {
+		statusByVolume[vi.VolumeName] = vi
+	}
+
+	allBound := true
+	for i := range vmRestore.Status.Restores {
+		vr := &vmRestore.Status.Restores[i]
+		vi, ok := statusByVolume[vr.VolumeName]
+		if !ok || vi.PersistentVolumeClaimName == nil {
+			allBound = false
+			continue
+		}
+		vr.PersistentVolumeClaimName = *vi.PersistentVolumeClaimName
+		if vi.ReadyToUse == nil || !*vi.ReadyToUse {
+			allBound = false
+		}
+	}
+	return allBound, nil
+}
+
+// snapshotHandleReady reports whether volumeSnapshotName's VolumeSnapshotContent
+// is actually safe to restore a PVC from. A CSI driver can bind a
+// VolumeSnapshot to a VolumeSnapshotContent that is still being provisioned
+// on the storage backend -- Velero's CSI plugin has hit this -- and
+// restoring from it then would silently produce an empty or truncated disk.
+// A snapshot is ready only once its content reports ReadyToUse and has a
+// SnapshotHandle; for pre-provisioned content (as reconcileCrossNamespaceSnapshot
+// creates), that handle must also already be set on the spec, not just
+// filled in asynchronously by the external-snapshotter sidecar.
+func (ctrl *VMRestoreController) snapshotHandleReady(namespace, volumeSnapshotName string) (bool, error) {
+	vsc, err := ctrl.VolumeSnapshotProvider.GetVolumeSnapshotContent(namespace, volumeSnapshotName)
+	if err != nil {
+		return false, err
+	}
+	if vsc == nil || vsc.Status == nil {
+		return false, nil
+	}
+	if vsc.Status.Error != nil && vsc.Status.Error.Message != nil {
+		return false, fmt.Errorf("VolumeSnapshotContent %s: %s", vsc.Name, *vsc.Status.Error.Message)
+	}
+	if vsc.Status.ReadyToUse == nil || !*vsc.Status.ReadyToUse || vsc.Status.SnapshotHandle == nil {
+		return false, nil
+	}
+	if vsc.Spec.Source.SnapshotHandle != nil && *vsc.Spec.Source.SnapshotHandle == "" {
+		return false, nil
+	}
+	return true, nil
+}
+
+// validateSnapshotPreflight checks, once snapshotHandleReady says the
+// snapshot's content is safe to restore from, that the VolumeSnapshot itself
+// is bound and that its content's driver agrees with the StorageClass the
+// restored PVC will land on. Skipping this let the controller create a PVC
+// that could never bind -- a VolumeSnapshotContent from one CSI driver can't
+// satisfy a PVC on a StorageClass provisioned by another. A failure here sets
+// a SnapshotNotReady/IncompatibleSnapshot condition and reports not-ready
+// rather than an error, so the caller requeues and re-checks instead of
+// treating a storage-backend timing issue as fatal.
+func (ctrl *VMRestoreController) validateSnapshotPreflight(vmRestore *snapshotv1.VirtualMachineRestore, backup *snapshotv1.VolumeBackup, override *snapshotv1.VolumeRestoreOverride, volumeSnapshotName string) (bool, error) {
+	vs, err := ctrl.VolumeSnapshotProvider.GetVolumeSnapshot(vmRestore.Namespace, volumeSnapshotName)
+	if err != nil {
+		return false, err
+	}
+	if vs == nil || vs.Status == nil || vs.Status.ReadyToUse == nil || !*vs.Status.ReadyToUse || vs.Status.BoundVolumeSnapshotContentName == nil {
+		setRestoreConditions(vmRestore,
+			corev1.ConditionFalse, "SnapshotNotReady",
+			corev1.ConditionFalse, "SnapshotNotReady")
+		return false, nil
+	}
+
+	scName := backup.PersistentVolumeClaim.Spec.StorageClassName
+	if override != nil && override.StorageClassName != nil {
+		scName = override.StorageClassName
+	}
+	if scName == nil {
+		return true, nil
+	}
+	targetSC, err := ctrl.getStorageClass(*scName)
+	if err != nil || targetSC == nil {
+		return true, err
+	}
+
+	vsc, err := ctrl.VolumeSnapshotProvider.GetVolumeSnapshotContent(vmRestore.Namespace, volumeSnapshotName)
+	if err != nil {
+		return false, err
+	}
+	if vsc != nil && vsc.Spec.Driver != targetSC.Provisioner {
+		ctrl.Recorder.Eventf(vmRestore, corev1.EventTypeWarning, "VirtualMachineRestoreError",
+			"volume %s: VolumeSnapshot driver %s does not match target StorageClass %s provisioner %s",
+			backup.VolumeName, vsc.Spec.Driver, *scName, targetSC.Provisioner)
+		setRestoreConditions(vmRestore,
+			corev1.ConditionFalse, "IncompatibleSnapshot",
+			corev1.ConditionFalse, "IncompatibleSnapshot")
+		return false, nil
+	}
+	return true, nil
+}
+
+// restoreSize returns templateSize, bumped up to volumeSnapshotName's
+// VolumeSnapshot.Status.RestoreSize when CSI reports that the snapshot needs
+// a larger claim than the original PVC requested (common with filesystem
+// volume modes, where the restored size is rounded up to a block boundary).
+// Shared by the whole-VM and single-disk restore controllers.
+func restoreSize(provider VolumeSnapshotProvider, namespace, volumeSnapshotName string, templateSize resource.Quantity) (resource.Quantity, error) {
+	vs, err := provider.GetVolumeSnapshot(namespace, volumeSnapshotName)
+	if err != nil {
+		return templateSize, err
+	}
+	if vs != nil && vs.Status != nil && vs.Status.RestoreSize != nil && vs.Status.RestoreSize.Cmp(templateSize) > 0 {
+		return *vs.Status.RestoreSize, nil
+	}
+	return templateSize, nil
+}
+
+// crossNamespaceVolumeSnapshotName is the VolumeSnapshot reconcileCrossNamespaceSnapshot
+// creates in the restore's target namespace, one per restored volume.
+func crossNamespaceVolumeSnapshotName(vmRestore *snapshotv1.VirtualMachineRestore, volumeName string) string {
+	return fmt.Sprintf("%s-%s", restoreID(vmRestore), volumeName)
+}
+
+// reconcileCrossNamespaceSnapshot makes sourceVolumeSnapshotName (which lives
+// in vmRestore.Namespace, alongside the VirtualMachineSnapshotContent it came
+// from) usable as a restore PVC's DataSource in the target namespace. CSI
+// VolumeSnapshots are namespaced, so when the target namespace differs it
+// clones the underlying VolumeSnapshotContent -- retaining the same
+// SnapshotHandle, so no new snapshot is taken on the storage backend -- into
+// a pre-provisioned VolumeSnapshotContent bound to a new VolumeSnapshot in
+// the target namespace, and returns that VolumeSnapshot's name. When the
+// namespaces match, it is a no-op and returns sourceVolumeSnapshotName as-is.
+func (ctrl *VMRestoreController) reconcileCrossNamespaceSnapshot(vmRestore *snapshotv1.VirtualMachineRestore, volumeName, sourceVolumeSnapshotName string) (string, bool, error) {
+	ns := targetNamespace(vmRestore)
+	if ns == vmRestore.Namespace {
+		return sourceVolumeSnapshotName, true, nil
+	}
+
+	name := crossNamespaceVolumeSnapshotName(vmRestore, volumeName)
+	vs, err := ctrl.VolumeSnapshotProvider.GetVolumeSnapshot(ns, name)
+	if err != nil {
+		return "", false, err
+	}
+	if vs != nil {
+		return name, vs.Status != nil && vs.Status.ReadyToUse != nil && *vs.Status.ReadyToUse, nil
+	}
+
+	sourceVS, err := ctrl.VolumeSnapshotProvider.GetVolumeSnapshot(vmRestore.Namespace, sourceVolumeSnapshotName)
+	if err != nil {
+		return "", false, err
+	}
+	if sourceVS == nil || sourceVS.Status == nil || sourceVS.Status.BoundVolumeSnapshotContentName == nil {
+		return "", false, fmt.Errorf("source VolumeSnapshot %s/%s is not yet bound", vmRestore.Namespace, sourceVolumeSnapshotName)
+	}
+
+	sourceContent, err := ctrl.VolumeSnapshotContentReader.GetVolumeSnapshotContent(*sourceVS.Status.BoundVolumeSnapshotContentName)
+	if err != nil {
+		return "", false, err
+	}
+	if sourceContent.Status == nil || sourceContent.Status.SnapshotHandle == nil {
+		return "", false, fmt.Errorf("VolumeSnapshotContent %s has no snapshot handle yet", sourceContent.Name)
+	}
+
+	contentName := name + "-content"
+	clonedContent := &vsv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        contentName,
+			Annotations: map[string]string{restorePVCAnnotation: vmRestore.Name},
+		},
+		Spec: vsv1.VolumeSnapshotContentSpec{
+			DeletionPolicy:          vsv1.VolumeSnapshotContentRetain,
+			Driver:                  sourceContent.Spec.Driver,
+			VolumeSnapshotClassName: sourceContent.Spec.VolumeSnapshotClassName,
+			Source: vsv1.VolumeSnapshotContentSource{
+				SnapshotHandle: sourceContent.Status.SnapshotHandle,
+			},
+			VolumeSnapshotRef: corev1.ObjectReference{
+				Namespace: ns,
+				Name:      name,
+			},
+		},
+	}
+	if _, err := ctrl.VolumeSnapshotContentReader.CreateVolumeSnapshotContent(clonedContent); err != nil && !errors.IsAlreadyExists(err) {
+		return "", false, err
+	}
+
+	newVS := &vsv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   ns,
+			Annotations: map[string]string{restorePVCAnnotation: vmRestore.Name},
+		},
+		Spec: vsv1.VolumeSnapshotSpec{
+			Source: vsv1.VolumeSnapshotSource{
+				VolumeSnapshotContentName: &contentName,
+			},
+		},
+	}
+	if _, err := ctrl.VolumeSnapshotProvider.CreateVolumeSnapshot(ns, newVS); err != nil && !errors.IsAlreadyExists(err) {
+		return "", false, err
+	}
+
+	return name, false, nil
+}
+
+// pvcGroupResource is the ResourceModifierConditions.GroupResource value
+// makeRestorePVC matches rules against.
+const pvcGroupResource = "persistentvolumeclaims"
+
+// finalizeRestorePVC applies override and modifiers to pvc, in that order,
+// the last step both makeRestorePVC (whole-VM restore, which has both) and
+// VMDiskRestoreController.reconcileDiskRestorePVC (single-volume restore,
+// which has neither and passes nil for both) take before creating the PVC.
+func finalizeRestorePVC(pvc *corev1.PersistentVolumeClaim, override *snapshotv1.VolumeRestoreOverride, modifiers *resourceModifierSet) ([]string, error) {
+	applyVolumeRestoreOverride(pvc, override)
+	return modifiers.apply(pvcGroupResource, pvc.Name, pvc)
+}
+
+func (ctrl *VMRestoreController) makeRestorePVC(vmRestore *snapshotv1.VirtualMachineRestore, vr snapshotv1.VolumeRestore, backup *snapshotv1.VolumeBackup, override *snapshotv1.VolumeRestoreOverride, volumeSnapshotName string, modifiers *resourceModifierSet) (*corev1.PersistentVolumeClaim, []string, error) {
+	ns := targetNamespace(vmRestore)
+
+	pvc := backup.PersistentVolumeClaim.DeepCopy()
+	pvc.Name = vr.PersistentVolumeClaimName
+	pvc.Namespace = ns
+	pvc.ResourceVersion = ""
+	pvc.UID = ""
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	pvc.Annotations[restorePVCAnnotation] = vmRestore.Name
+
+	// vr.DataSourceRef points the PVC at a generic populator CR (e.g. a
+	// Velero DataUploadResult) instead of a CSI VolumeSnapshot, for volumes
+	// backed up by a non-CSI backup engine. There is no VolumeSnapshot to
+	// query a RestoreSize from, so the backed-up PVC's own request size is
+	// used as-is.
+	if vr.DataSourceRef != nil {
+		pvc.Spec.DataSource = nil
+		pvc.Spec.DataSourceRef = vr.DataSourceRef
+
+		matched, err := finalizeRestorePVC(pvc, override, modifiers)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pvc, matched, nil
+	}
+
+	dataSource, size, err := snapshotRestoreDataSource(ctrl.VolumeSnapshotProvider, ns, volumeSnapshotName, backup.PersistentVolumeClaim.Spec.Resources.Requests[corev1.ResourceStorage])
+	if err != nil {
+		return nil, nil, err
+	}
+	pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: size}
+	pvc.Spec.DataSource = dataSource
+	pvc.Spec.DataSourceRef = nil
+
+	matched, err := finalizeRestorePVC(pvc, override, modifiers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pvc, matched, nil
+}
+
+func (ctrl *VMRestoreController) markVolumeRestoresReady(vmRestore *snapshotv1.VirtualMachineRestore) (bool, error) {
+	allReady := true
+	ns := targetNamespace(vmRestore)
+	for i := range vmRestore.Status.Restores {
+		vr := &vmRestore.Status.Restores[i]
+
+		key := controllerKey(ns, vr.PersistentVolumeClaimName)
+		obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(key)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, nil
+		}
+
+		pvc := obj.(*corev1.PersistentVolumeClaim)
+		if pvc.Status.Phase != corev1.ClaimBound {
+			allReady = false
+			continue
+		}
+
+		upsertVolumeInformation(vmRestore, vr.VolumeName, func(info *snapshotv1.VolumeRestoreInfo) {
+			info.PersistentVolumeName = pvc.Spec.VolumeName
+			if actual, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+				info.ActualCapacity = &actual
+			}
+			recordVolumePhase(info, "PVCBound")
+		})
+
+		if err := ctrl.reconcilePVOverride(ns, *vr, findVolumeRestoreOverride(vmRestore, vr.VolumeName)); err != nil {
+			return false, err
+		}
+
+		dvName := restoreDVName(vmRestore, vr.VolumeName)
+		vr.DataVolumeName = &dvName
+
+		if pvc.Annotations[populatedForPVCAnnotation] != dvName {
+			updated := pvc.DeepCopy()
+			if updated.Annotations == nil {
+				updated.Annotations = map[string]string{}
+			}
+			updated.Annotations[populatedForPVCAnnotation] = dvName
+			if _, err := ctrl.Client.CoreV1().PersistentVolumeClaims(ns).Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+				return false, err
+			}
+		}
+	}
+	return allReady, nil
+}
+
+func (ctrl *VMRestoreController) deleteDataVolumes(namespace string, names []string) error {
+	for _, name := range names {
+		err := ctrl.Client.CdiClient().CdiV1beta1().DataVolumes(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func initializeVolumeRestores(vmRestore *snapshotv1.VirtualMachineRestore, content *snapshotv1.VirtualMachineSnapshotContent) []snapshotv1.VolumeRestore {
+	var restores []snapshotv1.VolumeRestore
+	for _, backup := range content.Spec.VolumeBackups {
+		// A volume backed up by a non-CSI backup engine (e.g. a Velero
+		// DataUploadResult-style CR) carries a DataSourceRef instead of a
+		// VolumeSnapshotName -- see makeRestorePVC and
+		// reconcileVolumeRestorePVCs, which restore it straight from that
+		// populator instead of going through the CSI VolumeSnapshot path.
+		if backup.VolumeSnapshotName == nil && backup.DataSourceRef == nil {
+			continue
+		}
+		vr := snapshotv1.VolumeRestore{
+			VolumeName:                backup.VolumeName,
+			PersistentVolumeClaimName: restoreDVName(vmRestore, backup.VolumeName),
+			RestorePVSpec:             backup.RestorePVSpec,
+			DataSourceRef:             backup.DataSourceRef,
+		}
+		if backup.VolumeSnapshotName != nil {
+			vr.VolumeSnapshotName = *backup.VolumeSnapshotName
+		}
+		restores = append(restores, vr)
+	}
+	return restores
+}
+
+// initializeImportedVolumeRestores is initializeVolumeRestores's counterpart
+// for a Spec.Source-driven restore: the volume list comes from the
+// VirtualMachineSnapshotImport's Spec.Volumes (the manifest's volumes)
+// instead of a VirtualMachineSnapshotContent's VolumeBackups, and
+// PersistentVolumeClaimName/VolumeSnapshotName are filled in later, by
+// reconcileImportedRestorePVCs, once the import has actually downloaded them.
+func initializeImportedVolumeRestores(imp *VirtualMachineSnapshotImport) []snapshotv1.VolumeRestore {
+	var restores []snapshotv1.VolumeRestore
+	for _, volume := range imp.Spec.Volumes {
+		restores = append(restores, snapshotv1.VolumeRestore{VolumeName: volume.Name})
+	}
+	return restores
+}
+
+func findVolumeBackup(content *snapshotv1.VirtualMachineSnapshotContent, volumeName string) *snapshotv1.VolumeBackup {
+	for i := range content.Spec.VolumeBackups {
+		if content.Spec.VolumeBackups[i].VolumeName == volumeName {
+			return &content.Spec.VolumeBackups[i]
+		}
+	}
+	return nil
+}
+
+// Values recorded in VolumeRestoreInfo.RestoreMethod (see
+// upsertVolumeInformation), one per way reconcileVolumeRestorePVCs can
+// materialize a restored volume's PVC.
+const (
+	restoreMethodCSISnapshot = "CSISnapshot"
+	restoreMethodPopulator   = "GenericPopulator"
+)
+
+// upsertVolumeInformation finds volumeName's entry in
+// vmRestore.Status.VolumesInformation (Velero-style per-volume restore
+// lineage for describe/inspect tooling), creating one on its first phase
+// transition, and lets set mutate it in place -- each phase transition only
+// fills in the fields it has new information for, leaving earlier phases'
+// fields untouched.
+func upsertVolumeInformation(vmRestore *snapshotv1.VirtualMachineRestore, volumeName string, set func(*snapshotv1.VolumeRestoreInfo)) {
+	for i := range vmRestore.Status.VolumesInformation {
+		if vmRestore.Status.VolumesInformation[i].VolumeName == volumeName {
+			set(&vmRestore.Status.VolumesInformation[i])
+			return
+		}
+	}
+	info := snapshotv1.VolumeRestoreInfo{VolumeName: volumeName}
+	set(&info)
+	vmRestore.Status.VolumesInformation = append(vmRestore.Status.VolumesInformation, info)
+}
+
+// recordVolumePhase stamps phase with the current time in info's
+// PhaseTimestamps, initializing the map on first use.
+func recordVolumePhase(info *snapshotv1.VolumeRestoreInfo, phase string) {
+	if info.PhaseTimestamps == nil {
+		info.PhaseTimestamps = map[string]metav1.Time{}
+	}
+	info.PhaseTimestamps[phase] = *currentTime()
+}
+
+// recordVolumeRestorePVCCreated records vr's VolumesInformation entry for
+// the "PVCCreated" phase transition, right after reconcileVolumeRestorePVCs
+// has successfully submitted its PVC. driver is the empty string for a
+// DataSourceRef-sourced (generic populator) restore, which has no CSI
+// VolumeSnapshotContent to read a driver name from.
+func recordVolumeRestorePVCCreated(vmRestore *snapshotv1.VirtualMachineRestore, vr snapshotv1.VolumeRestore, pvc *corev1.PersistentVolumeClaim, method, driver string) {
+	upsertVolumeInformation(vmRestore, vr.VolumeName, func(info *snapshotv1.VolumeRestoreInfo) {
+		info.SourceVolumeSnapshot = vr.VolumeSnapshotName
+		info.RestoreMethod = method
+		info.CSIDriver = driver
+		info.PersistentVolumeClaimName = pvc.Name
+		if pvc.Spec.StorageClassName != nil {
+			info.StorageClassName = *pvc.Spec.StorageClassName
+		}
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		info.RequestedCapacity = &requested
+		recordVolumePhase(info, "PVCCreated")
+	})
+}
+
+// snapshotRestoreDataSource computes the CSI DataSource reference and sized
+// storage request for a PVC restored from volumeSnapshotName, bumping
+// requestSize to the VolumeSnapshot's RestoreSize if larger. Shared by
+// makeRestorePVC and the disk-restore controller's PVC builders (see
+// diskrestore.go), which otherwise assemble the rest of the PVC differently.
+func snapshotRestoreDataSource(provider VolumeSnapshotProvider, namespace, volumeSnapshotName string, requestSize resource.Quantity) (*corev1.TypedLocalObjectReference, resource.Quantity, error) {
+	size, err := restoreSize(provider, namespace, volumeSnapshotName, requestSize)
+	if err != nil {
+		return nil, resource.Quantity{}, err
+	}
+	return &corev1.TypedLocalObjectReference{
+		APIGroup: pointer.String(vsv1.GroupName),
+		Kind:     "VolumeSnapshot",
+		Name:     volumeSnapshotName,
+	}, size, nil
+}
+
+// findVolumeRestoreOverride looks up vmRestore.Spec.VolumeRestoreOverrides for
+// volumeName, returning nil when the volume has no override.
+func findVolumeRestoreOverride(vmRestore *snapshotv1.VirtualMachineRestore, volumeName string) *snapshotv1.VolumeRestoreOverride {
+	for i := range vmRestore.Spec.VolumeRestoreOverrides {
+		if vmRestore.Spec.VolumeRestoreOverrides[i].VolumeName == volumeName {
+			return &vmRestore.Spec.VolumeRestoreOverrides[i]
+		}
+	}
+	return nil
+}
+
+// getStorageClass looks up name in the StorageClassInformer, returning nil
+// (not an error) when it does not exist.
+func (ctrl *VMRestoreController) getStorageClass(name string) (*storagev1.StorageClass, error) {
+	obj, exists, err := ctrl.StorageClassInformer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return obj.(*storagev1.StorageClass), nil
+}
+
+// validateVolumeRestoreOverride enforces the invariant Velero's restore hooks
+// also rely on: a storage-class change must land on a StorageClass that
+// actually exists, and a cross-provisioner move -- one whose driver differs
+// from the volume's original StorageClass -- must not proceed unless a
+// snapshot re-hydration path (VolumeSnapshotClassName) is configured,
+// otherwise CSI will bind the restored PVC straight to the old provisioner's
+// snapshot and fail.
+func (ctrl *VMRestoreController) validateVolumeRestoreOverride(backup *snapshotv1.VolumeBackup, override *snapshotv1.VolumeRestoreOverride) error {
+	if override == nil || override.StorageClassName == nil {
+		return nil
+	}
+
+	targetSC, err := ctrl.getStorageClass(*override.StorageClassName)
+	if err != nil {
+		return err
+	}
+	if targetSC == nil {
+		return fmt.Errorf("target StorageClass %s does not exist", *override.StorageClassName)
+	}
+
+	sourceSCName := backup.PersistentVolumeClaim.Spec.StorageClassName
+	if sourceSCName == nil || *sourceSCName == *override.StorageClassName {
+		return nil
+	}
+	sourceSC, err := ctrl.getStorageClass(*sourceSCName)
+	if err != nil {
+		return err
+	}
+	if sourceSC == nil || sourceSC.Provisioner == targetSC.Provisioner {
+		return nil
+	}
+	if override.VolumeSnapshotClassName == nil {
+		return fmt.Errorf("volume %s: restoring into StorageClass %s changes provisioner from %s to %s, set volumeSnapshotClassName to allow the cross-provisioner move",
+			backup.VolumeName, *override.StorageClassName, sourceSC.Provisioner, targetSC.Provisioner)
+	}
+	return nil
+}
+
+// applyVolumeRestoreOverride rewrites pvc in place with any per-volume
+// overrides from VirtualMachineRestoreSpec.VolumeRestoreOverrides, layering
+// on top of the PVC template makeRestorePVC already cloned from the
+// VolumeBackup.
+func applyVolumeRestoreOverride(pvc *corev1.PersistentVolumeClaim, override *snapshotv1.VolumeRestoreOverride) {
+	if override == nil {
+		return
+	}
+	if override.StorageClassName != nil {
+		pvc.Spec.StorageClassName = override.StorageClassName
+	}
+	if len(override.AccessModes) > 0 {
+		pvc.Spec.AccessModes = override.AccessModes
+	}
+	if override.VolumeMode != nil {
+		pvc.Spec.VolumeMode = override.VolumeMode
+	}
+	if override.Storage != nil {
+		pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: *override.Storage}
+	}
+	for k, v := range override.Labels {
+		if pvc.Labels == nil {
+			pvc.Labels = map[string]string{}
+		}
+		pvc.Labels[k] = v
+	}
+	for k, v := range override.Annotations {
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		pvc.Annotations[k] = v
+	}
+}
+
+// reconcilePVOverride patches the PersistentVolume bound to vr's PVC with
+// override's ReclaimPolicy/NodeAffinity, mirroring Velero's "patch newly
+// dynamically provisioned PV with volume info" restore step -- the PVC/PV
+// binding can't carry those fields at creation time, so they're applied
+// once the PV exists.
+func (ctrl *VMRestoreController) reconcilePVOverride(namespace string, vr snapshotv1.VolumeRestore, override *snapshotv1.VolumeRestoreOverride) error {
+	if override == nil || (override.ReclaimPolicy == nil && override.NodeAffinity == nil) {
+		return nil
+	}
+
+	key := controllerKey(namespace, vr.PersistentVolumeClaimName)
+	obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	pvc := obj.(*corev1.PersistentVolumeClaim)
+	if pvc.Spec.VolumeName == "" {
+		return nil
+	}
+
+	pv, err := ctrl.Client.CoreV1().PersistentVolumes().Get(context.Background(), pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	updated := pv.DeepCopy()
+	if override.ReclaimPolicy != nil {
+		updated.Spec.PersistentVolumeReclaimPolicy = *override.ReclaimPolicy
+	}
+	if override.NodeAffinity != nil {
+		updated.Spec.NodeAffinity = override.NodeAffinity
+	}
+	if equality.Semantic.DeepEqual(pv.Spec, updated.Spec) {
+		return nil
+	}
+
+	_, err = ctrl.Client.CoreV1().PersistentVolumes().Update(context.Background(), updated, metav1.UpdateOptions{})
+	return err
+}
+
+func dataVolumeTemplateNames(vm *v1.VirtualMachine) []string {
+	if vm == nil {
+		return nil
+	}
+	var names []string
+	for _, dvt := range vm.Spec.DataVolumeTemplates {
+		names = append(names, dvt.Name)
+	}
+	return names
+}
+
+// restoreTarget is the object a VirtualMachineRestore writes its restored
+// disks onto. It is almost always an existing VirtualMachine, but when
+// Spec.Target names a VM that does not exist yet, Reconcile recreates one
+// from the snapshot content (optionally rewritten by Spec.Patches).
+type restoreTarget interface {
+	Exists() bool
+	VM() *v1.VirtualMachine
+	OwnerReference() metav1.OwnerReference
+	UpdateRestoreInProgress() error
+	UpdateDoneRestore() error
+	UpdateTarget(vmRestore *snapshotv1.VirtualMachineRestore) (bool, error)
+	Reconcile() (bool, error)
+}
+
+type vmRestoreTarget struct {
+	controller *VMRestoreController
+	vmRestore  *snapshotv1.VirtualMachineRestore
+	content    *snapshotv1.VirtualMachineSnapshotContent
+	vm         *v1.VirtualMachine
+}
+
+func (ctrl *VMRestoreController) getTarget(vmRestore *snapshotv1.VirtualMachineRestore) (restoreTarget, error) {
+	if vmRestore.Spec.Target.Kind != "VirtualMachine" {
+		return nil, fmt.Errorf("unsupported restore target kind %q", vmRestore.Spec.Target.Kind)
+	}
+
+	target := &vmRestoreTarget{controller: ctrl, vmRestore: vmRestore}
+
+	key := controllerKey(targetNamespace(vmRestore), vmRestore.Spec.Target.Name)
+	obj, exists, err := ctrl.VMInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		target.vm = obj.(*v1.VirtualMachine).DeepCopy()
+	}
+
+	if vmRestore.Spec.Source != nil {
+		// A Spec.Source-driven (DR/migration) restore has no
+		// VirtualMachineSnapshotContent to recreate a missing target VM
+		// from -- it must already exist.
+		if target.vm == nil {
+			return nil, fmt.Errorf("restore target %s does not exist", key)
+		}
+		return target, nil
+	}
+
+	content, err := ctrl.getSnapshotContent(vmRestore)
+	if err == nil {
+		target.content = content
+	} else if target.vm == nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+func (rt *vmRestoreTarget) Exists() bool {
+	return rt.vm != nil
+}
+
+func (rt *vmRestoreTarget) VM() *v1.VirtualMachine {
+	return rt.vm
+}
+
+func (rt *vmRestoreTarget) OwnerReference() metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion:         v1.GroupVersion.String(),
+		Kind:               "VirtualMachine",
+		Name:               rt.vm.Name,
+		UID:                rt.vm.UID,
+		Controller:         &t,
+		BlockOwnerDeletion: &t,
+	}
+}
+
+func (rt *vmRestoreTarget) UpdateRestoreInProgress() error {
+	if rt.vm == nil {
+		return nil
+	}
+	if rt.vm.Status.RestoreInProgress != nil && *rt.vm.Status.RestoreInProgress == rt.vmRestore.Name {
+		return nil
+	}
+
+	updated := rt.vm.DeepCopy()
+	updated.Status.RestoreInProgress = &rt.vmRestore.Name
+	newVM, err := rt.controller.vmStatusUpdater.UpdateStatus(updated)
+	if err != nil {
+		return err
+	}
+	rt.vm = newVM
+	return nil
+}
+
+func (rt *vmRestoreTarget) UpdateDoneRestore() error {
+	if rt.vm == nil || rt.vm.Status.RestoreInProgress == nil {
+		return nil
+	}
+
+	updated := rt.vm.DeepCopy()
+	updated.Status.RestoreInProgress = nil
+	newVM, err := rt.controller.vmStatusUpdater.UpdateStatus(updated)
+	if err != nil {
+		return err
+	}
+	rt.vm = newVM
+	return nil
+}
+
+func (rt *vmRestoreTarget) UpdateTarget(vmRestore *snapshotv1.VirtualMachineRestore) (bool, error) {
+	if rt.vm == nil {
+		return false, fmt.Errorf("restore target %s/%s does not exist", targetNamespace(vmRestore), vmRestore.Spec.Target.Name)
+	}
+	if rt.vm.Annotations[lastRestoreAnnotation] == restoreID(vmRestore) {
+		return false, nil
+	}
+
+	updated := rt.vm.DeepCopy()
+
+	newNameByOldDV := map[string]string{}
+	if updated.Spec.Template != nil {
+		for _, vr := range vmRestore.Status.Restores {
+			if vr.DataVolumeName == nil {
+				continue
+			}
+			for _, vol := range updated.Spec.Template.Spec.Volumes {
+				if vol.Name == vr.VolumeName && vol.DataVolume != nil {
+					newNameByOldDV[vol.DataVolume.Name] = *vr.DataVolumeName
+				}
+			}
+		}
+	}
+
+	for i := range updated.Spec.DataVolumeTemplates {
+		if newName, ok := newNameByOldDV[updated.Spec.DataVolumeTemplates[i].Name]; ok {
+			updated.Spec.DataVolumeTemplates[i].Name = newName
+		}
+	}
+	if updated.Spec.Template != nil {
+		for i := range updated.Spec.Template.Spec.Volumes {
+			vol := &updated.Spec.Template.Spec.Volumes[i]
+			if vol.DataVolume == nil {
+				continue
+			}
+			if newName, ok := newNameByOldDV[vol.DataVolume.Name]; ok {
+				vol.DataVolume.Name = newName
+			}
+		}
+	}
+
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[lastRestoreAnnotation] = restoreID(vmRestore)
+
+	newVM, err := rt.controller.Client.VirtualMachine(targetNamespace(vmRestore)).Update(updated)
+	if err != nil {
+		return false, err
+	}
+	rt.vm = newVM
+	return true, nil
+}
+
+func (rt *vmRestoreTarget) Reconcile() (bool, error) {
+	if rt.vm != nil {
+		return false, nil
+	}
+	if rt.content == nil {
+		return false, fmt.Errorf("no VirtualMachineSnapshotContent available to recreate restore target")
+	}
+
+	newVM, matched, err := makeVMFromContent(rt.content, rt.vmRestore)
+	if err != nil {
+		return false, err
+	}
+	setPatchAppliedCondition(rt.vmRestore, matched)
+
+	created, err := rt.controller.Client.VirtualMachine(targetNamespace(rt.vmRestore)).Create(newVM)
+	if err != nil {
+		return false, err
+	}
+	rt.vm = created
+	return true, nil
+}
+
+// virtualMachineGroupResource and dataVolumeGroupResource are the
+// ResourceModifierConditions.GroupResource values makeVMFromContent matches
+// rules against.
+const (
+	virtualMachineGroupResource = "virtualmachines.kubevirt.io"
+	dataVolumeGroupResource     = "datavolumes.cdi.kubevirt.io"
+)
+
+// makeVMFromContent rebuilds the VirtualMachine a VirtualMachineSnapshotContent
+// captured, renamed onto vmRestore's target, for the "restore to a VM that
+// does not exist yet" flow. Three layers of rewriting apply on top of the
+// captured VM, in order: Spec.Patches (RFC 6902 JSON Patch), Spec.MergePatches
+// (RFC 7396 JSON Merge Patch), then Spec.ResourceModifiers -- compiled once
+// here and also applied to each DataVolumeTemplate the VM carries, so e.g. a
+// StorageClass retarget reaches both the VM and the volumes it provisions.
+// It returns one "<groupResource>[<rule index>]" entry per ResourceModifierRule
+// that matched, for the restore's PatchApplied condition.
+func makeVMFromContent(content *snapshotv1.VirtualMachineSnapshotContent, vmRestore *snapshotv1.VirtualMachineRestore) (*v1.VirtualMachine, []string, error) {
+	if content.Spec.Source.VirtualMachine == nil {
+		return nil, nil, fmt.Errorf("VirtualMachineSnapshotContent %s has no stored VirtualMachine", content.Name)
+	}
+
+	vm := &v1.VirtualMachine{
+		ObjectMeta: *content.Spec.Source.VirtualMachine.ObjectMeta.DeepCopy(),
+		Spec:       *content.Spec.Source.VirtualMachine.Spec.DeepCopy(),
+	}
+	vm.Name = vmRestore.Spec.Target.Name
+	vm.Namespace = targetNamespace(vmRestore)
+	vm.ResourceVersion = ""
+	vm.UID = ""
+	vm.Status = v1.VirtualMachineStatus{}
+
+	if len(vmRestore.Spec.Patches) > 0 {
+		encoded, err := json.Marshal(vm)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, p := range vmRestore.Spec.Patches {
+			patch, err := jsonpatch.DecodePatch([]byte("[" + p + "]"))
+			if err != nil {
+				return nil, nil, err
+			}
+			encoded, err = patch.Apply(encoded)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		vm = &v1.VirtualMachine{}
+		if err := json.Unmarshal(encoded, vm); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, p := range vmRestore.Spec.MergePatches {
+		encoded, err := json.Marshal(vm)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded, err = jsonpatch.MergePatch(encoded, []byte(p))
+		if err != nil {
+			return nil, nil, err
+		}
+		vm = &v1.VirtualMachine{}
+		if err := json.Unmarshal(encoded, vm); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	modifiers, err := compileResourceModifiers(vmRestore.Spec.ResourceModifiers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var matched []string
+	vmMatched, err := modifiers.apply(virtualMachineGroupResource, vm.Name, vm)
+	if err != nil {
+		return nil, nil, err
+	}
+	matched = append(matched, vmMatched...)
+
+	for i := range vm.Spec.DataVolumeTemplates {
+		dvMatched, err := modifiers.apply(dataVolumeGroupResource, vm.Spec.DataVolumeTemplates[i].Name, &vm.Spec.DataVolumeTemplates[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		matched = append(matched, dvMatched...)
+	}
+
+	return vm, matched, nil
+}
@@ -8,8 +8,11 @@ import (
 	vsv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -60,7 +63,7 @@ var _ = Describe("Restore controlleer", func() {
 			},
 			Status: &snapshotv1.VirtualMachineRestoreStatus{},
 			Spec: snapshotv1.VirtualMachineRestoreSpec{
-				Target: corev1.TypedLocalObjectReference{
+				Target: corev1.TypedObjectReference{
 					APIGroup: &vmAPIGroup,
 					Kind:     "VirtualMachine",
 					Name:     vmName,
@@ -165,12 +168,36 @@ var _ = Describe("Restore controlleer", func() {
 	}
 
 	createVolumeSnapshot := func(name string, restoreSize resource.Quantity) *vsv1.VolumeSnapshot {
+		contentName := name + "-content"
 		return &vsv1.VolumeSnapshot{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: name,
 			},
 			Status: &vsv1.VolumeSnapshotStatus{
-				RestoreSize: &restoreSize,
+				RestoreSize:                    &restoreSize,
+				BoundVolumeSnapshotContentName: &contentName,
+			},
+		}
+	}
+
+	// createReadyVolumeSnapshotContent makes vs's VolumeSnapshotContent pass
+	// snapshotHandleReady: ready to use, with a SnapshotHandle both reported
+	// in status and (as a pre-provisioned restore PVC's DataSource would
+	// need) set on the spec.
+	createReadyVolumeSnapshotContent := func(vs *vsv1.VolumeSnapshot) *vsv1.VolumeSnapshotContent {
+		handle := "snapshot-handle-" + vs.Name
+		return &vsv1.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: *vs.Status.BoundVolumeSnapshotContentName,
+			},
+			Spec: vsv1.VolumeSnapshotContentSpec{
+				Source: vsv1.VolumeSnapshotContentSource{
+					SnapshotHandle: &handle,
+				},
+			},
+			Status: &vsv1.VolumeSnapshotContentStatus{
+				ReadyToUse:     &t,
+				SnapshotHandle: &handle,
 			},
 		}
 	}
@@ -205,11 +232,15 @@ var _ = Describe("Restore controlleer", func() {
 		var storageClassInformer cache.SharedIndexInformer
 		var storageClassSource *framework.FakeControllerSource
 
+		var jobInformer cache.SharedIndexInformer
+		var jobSource *framework.FakeControllerSource
+
 		var stop chan struct{}
 		var controller *VMRestoreController
 		var recorder *record.FakeRecorder
 		var mockVMRestoreQueue *testutils.MockWorkQueue
 		var fakeVolumeSnapshotProvider *MockVolumeSnapshotProvider
+		var vscReader *MockVolumeSnapshotContentReader
 
 		var kubevirtClient *kubevirtfake.Clientset
 		var k8sClient *k8sfake.Clientset
@@ -224,6 +255,7 @@ var _ = Describe("Restore controlleer", func() {
 			go vmiInformer.Run(stop)
 			go dataVolumeInformer.Run(stop)
 			go storageClassInformer.Run(stop)
+			go jobInformer.Run(stop)
 			Expect(cache.WaitForCacheSync(
 				stop,
 				vmRestoreInformer.HasSynced,
@@ -234,6 +266,7 @@ var _ = Describe("Restore controlleer", func() {
 				vmiInformer.HasSynced,
 				dataVolumeInformer.HasSynced,
 				storageClassInformer.HasSynced,
+				jobInformer.HasSynced,
 			)).To(BeTrue())
 		}
 
@@ -251,6 +284,7 @@ var _ = Describe("Restore controlleer", func() {
 			dataVolumeInformer, dataVolumeSource = testutils.NewFakeInformerFor(&cdiv1.DataVolume{})
 			pvcInformer, pvcSource = testutils.NewFakeInformerFor(&corev1.PersistentVolumeClaim{})
 			storageClassInformer, storageClassSource = testutils.NewFakeInformerFor(&storagev1.StorageClass{})
+			jobInformer, jobSource = testutils.NewFakeInformerFor(&batchv1.Job{})
 
 			recorder = record.NewFakeRecorder(100)
 			recorder.IncludeObject = true
@@ -258,20 +292,23 @@ var _ = Describe("Restore controlleer", func() {
 			fakeVolumeSnapshotProvider = &MockVolumeSnapshotProvider{
 				volumeSnapshots: []*vsv1.VolumeSnapshot{},
 			}
+			vscReader = &MockVolumeSnapshotContentReader{}
 
 			controller = &VMRestoreController{
-				Client:                    virtClient,
-				VMRestoreInformer:         vmRestoreInformer,
-				VMSnapshotInformer:        vmSnapshotInformer,
-				VMSnapshotContentInformer: vmSnapshotContentInformer,
-				VMInformer:                vmInformer,
-				VMIInformer:               vmiInformer,
-				PVCInformer:               pvcInformer,
-				StorageClassInformer:      storageClassInformer,
-				DataVolumeInformer:        dataVolumeInformer,
-				Recorder:                  recorder,
-				vmStatusUpdater:           status.NewVMStatusUpdater(virtClient),
-				VolumeSnapshotProvider:    fakeVolumeSnapshotProvider,
+				Client:                      virtClient,
+				VMRestoreInformer:           vmRestoreInformer,
+				VMSnapshotInformer:          vmSnapshotInformer,
+				VMSnapshotContentInformer:   vmSnapshotContentInformer,
+				VMInformer:                  vmInformer,
+				VMIInformer:                 vmiInformer,
+				PVCInformer:                 pvcInformer,
+				StorageClassInformer:        storageClassInformer,
+				DataVolumeInformer:          dataVolumeInformer,
+				JobInformer:                 jobInformer,
+				Recorder:                    recorder,
+				vmStatusUpdater:             status.NewVMStatusUpdater(virtClient),
+				VolumeSnapshotProvider:      fakeVolumeSnapshotProvider,
+				VolumeSnapshotContentReader: vscReader,
 			}
 			controller.Init()
 
@@ -293,6 +330,8 @@ var _ = Describe("Restore controlleer", func() {
 
 			k8sClient = k8sfake.NewSimpleClientset()
 			virtClient.EXPECT().CoreV1().Return(k8sClient.CoreV1()).AnyTimes()
+			virtClient.EXPECT().BatchV1().Return(k8sClient.BatchV1()).AnyTimes()
+			virtClient.EXPECT().AuthorizationV1().Return(k8sClient.AuthorizationV1()).AnyTimes()
 
 			cdiClient = cdifake.NewSimpleClientset()
 			virtClient.EXPECT().CdiClient().Return(cdiClient).AnyTimes()
@@ -440,12 +479,60 @@ var _ = Describe("Restore controlleer", func() {
 				pvcSize := resource.MustParse("2Gi")
 				vs := createVolumeSnapshot(r.Status.Restores[0].VolumeSnapshotName, pvcSize)
 				fakeVolumeSnapshotProvider.Add(vs)
+				fakeVolumeSnapshotProvider.AddContent(r.Namespace, vs.Name, createReadyVolumeSnapshotContent(vs))
 				expectUpdateVMRestoreInProgress(vm)
 				expectPVCCreates(k8sClient, r, pvcSize)
 				addVirtualMachineRestore(r)
 				controller.processVMRestoreWorkItem()
 			})
 
+			It("should apply a matching VolumeRestoreOverride to the created restore PVC", func() {
+				r := createRestoreWithOwner()
+				vm := createModifiedVM()
+				r.Status = &snapshotv1.VirtualMachineRestoreStatus{
+					Complete: &f,
+					Conditions: []snapshotv1.Condition{
+						newProgressingCondition(corev1.ConditionTrue, "Creating new PVCs"),
+						newReadyCondition(corev1.ConditionFalse, "Waiting for new PVCs"),
+					},
+				}
+				vmSource.Add(vm)
+				addVolumeRestores(r)
+
+				overrideStorageClass := "override-sc"
+				r.Spec.VolumeRestoreOverrides = []snapshotv1.VolumeRestoreOverride{
+					{
+						VolumeName:       r.Status.Restores[0].VolumeName,
+						StorageClassName: &overrideStorageClass,
+						Labels:           map[string]string{"override-label": "true"},
+						Annotations:      map[string]string{"override-annotation": "true"},
+					},
+				}
+				storageClassSource.Add(&storagev1.StorageClass{
+					ObjectMeta:  metav1.ObjectMeta{Name: overrideStorageClass},
+					Provisioner: "csi.example.com",
+				})
+
+				pvcSize := resource.MustParse("2Gi")
+				vs := createVolumeSnapshot(r.Status.Restores[0].VolumeSnapshotName, pvcSize)
+				fakeVolumeSnapshotProvider.Add(vs)
+				fakeVolumeSnapshotProvider.AddContent(r.Namespace, vs.Name, createReadyVolumeSnapshotContent(vs))
+				expectUpdateVMRestoreInProgress(vm)
+
+				k8sClient.Fake.PrependReactor("create", "persistentvolumeclaims", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+					create, ok := action.(testing.CreateAction)
+					Expect(ok).To(BeTrue())
+					pvc := create.GetObject().(*corev1.PersistentVolumeClaim)
+					Expect(*pvc.Spec.StorageClassName).To(Equal(overrideStorageClass))
+					Expect(pvc.Labels).To(HaveKeyWithValue("override-label", "true"))
+					Expect(pvc.Annotations).To(HaveKeyWithValue("override-annotation", "true"))
+					return true, create.GetObject(), nil
+				})
+
+				addVirtualMachineRestore(r)
+				controller.processVMRestoreWorkItem()
+			})
+
 			It("should create restore PVC with volume snapshot size if bigger then PVC size", func() {
 				r := createRestoreWithOwner()
 				vm := createModifiedVM()
@@ -461,6 +548,7 @@ var _ = Describe("Restore controlleer", func() {
 				q := resource.MustParse("3Gi")
 				vs := createVolumeSnapshot(r.Status.Restores[0].VolumeSnapshotName, q)
 				fakeVolumeSnapshotProvider.Add(vs)
+				fakeVolumeSnapshotProvider.AddContent(r.Namespace, vs.Name, createReadyVolumeSnapshotContent(vs))
 				expectUpdateVMRestoreInProgress(vm)
 				expectPVCCreates(k8sClient, r, q)
 				addVirtualMachineRestore(r)
@@ -482,6 +570,7 @@ var _ = Describe("Restore controlleer", func() {
 				q := resource.MustParse("1Gi")
 				vs := createVolumeSnapshot(r.Status.Restores[0].VolumeSnapshotName, q)
 				fakeVolumeSnapshotProvider.Add(vs)
+				fakeVolumeSnapshotProvider.AddContent(r.Namespace, vs.Name, createReadyVolumeSnapshotContent(vs))
 				expectUpdateVMRestoreInProgress(vm)
 				pvcSize := resource.MustParse("2Gi")
 				expectPVCCreates(k8sClient, r, pvcSize)
@@ -489,6 +578,135 @@ var _ = Describe("Restore controlleer", func() {
 				controller.processVMRestoreWorkItem()
 			})
 
+			It("should not create a restore PVC until the VolumeSnapshotContent reports a SnapshotHandle", func() {
+				r := createRestoreWithOwner()
+				vm := createModifiedVM()
+				r.Status = &snapshotv1.VirtualMachineRestoreStatus{
+					Complete: &f,
+					Conditions: []snapshotv1.Condition{
+						newProgressingCondition(corev1.ConditionTrue, "Creating new PVCs"),
+						newReadyCondition(corev1.ConditionFalse, "Waiting for new PVCs"),
+					},
+				}
+				vmSource.Add(vm)
+				addVolumeRestores(r)
+				vs := createVolumeSnapshot(r.Status.Restores[0].VolumeSnapshotName, resource.MustParse("2Gi"))
+				fakeVolumeSnapshotProvider.Add(vs)
+				// No AddContent call: the VolumeSnapshotContent is unresolvable, as
+				// if the CSI driver has not finished provisioning it yet.
+				expectUpdateVMRestoreInProgress(vm)
+				rc := r.DeepCopy()
+				rc.ResourceVersion = "1"
+				rc.Status.Conditions = []snapshotv1.Condition{
+					newProgressingCondition(corev1.ConditionFalse, "WaitingForSnapshotHandle"),
+					newReadyCondition(corev1.ConditionFalse, "WaitingForSnapshotHandle"),
+				}
+				expectVMRestoreUpdate(kubevirtClient, rc)
+				addVirtualMachineRestore(r)
+				controller.processVMRestoreWorkItem()
+				Expect(k8sClient.Actions()).To(BeEmpty())
+			})
+
+			DescribeTable("snapshotHandleReady", func(vsc *vsv1.VolumeSnapshotContent, expectedReady bool, expectedErr bool) {
+				vsName := "vmsnapshot-snapshot-uid-volume-disk1"
+				if vsc != nil {
+					fakeVolumeSnapshotProvider.AddContent(testNamespace, vsName, vsc)
+				}
+				ready, err := controller.snapshotHandleReady(testNamespace, vsName)
+				if expectedErr {
+					Expect(err).To(HaveOccurred())
+				} else {
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(ready).To(Equal(expectedReady))
+			},
+				Entry("VolumeSnapshotContent not yet resolvable", nil, false, false),
+				Entry("VolumeSnapshotContent exists but has no status yet", &vsv1.VolumeSnapshotContent{
+					ObjectMeta: metav1.ObjectMeta{Name: "vsc-no-status"},
+				}, false, false),
+				Entry("VolumeSnapshotContent not ready", &vsv1.VolumeSnapshotContent{
+					ObjectMeta: metav1.ObjectMeta{Name: "vsc-not-ready"},
+					Status:     &vsv1.VolumeSnapshotContentStatus{ReadyToUse: &f},
+				}, false, false),
+				Entry("VolumeSnapshotContent ready with a handle", &vsv1.VolumeSnapshotContent{
+					ObjectMeta: metav1.ObjectMeta{Name: "vsc-ready"},
+					Spec: vsv1.VolumeSnapshotContentSpec{
+						Source: vsv1.VolumeSnapshotContentSource{SnapshotHandle: pointer.String("handle-1")},
+					},
+					Status: &vsv1.VolumeSnapshotContentStatus{
+						ReadyToUse:     &t,
+						SnapshotHandle: pointer.String("handle-1"),
+					},
+				}, true, false),
+				Entry("VolumeSnapshotContent failed", &vsv1.VolumeSnapshotContent{
+					ObjectMeta: metav1.ObjectMeta{Name: "vsc-failed"},
+					Status: &vsv1.VolumeSnapshotContentStatus{
+						Error: &vsv1.VolumeSnapshotError{Message: pointer.String("backend out of space")},
+					},
+				}, false, true),
+			)
+
+			DescribeTable("validateSnapshotPreflight", func(vs *vsv1.VolumeSnapshot, vsc *vsv1.VolumeSnapshotContent, expectedReady bool) {
+				vsName := "preflight-vs"
+				backup := &snapshotv1.VolumeBackup{
+					VolumeName: "disk1",
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaim{
+						Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &storageClassName},
+					},
+				}
+				storageClassSource.Add(&storagev1.StorageClass{
+					ObjectMeta:  metav1.ObjectMeta{Name: storageClassName},
+					Provisioner: "csi.example.com",
+				})
+				syncCaches(stop)
+
+				if vs != nil {
+					fakeVolumeSnapshotProvider.Add(vs)
+				}
+				if vsc != nil {
+					fakeVolumeSnapshotProvider.AddContent(testNamespace, vsName, vsc)
+				}
+
+				r := createRestoreWithOwner()
+				ready, err := controller.validateSnapshotPreflight(r, backup, nil, vsName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ready).To(Equal(expectedReady))
+				if !expectedReady {
+					condition := getRestoreCondition(r, snapshotv1.ConditionReady)
+					Expect(condition).ToNot(BeNil())
+					Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+				}
+			},
+				Entry("VolumeSnapshot not yet ready", &vsv1.VolumeSnapshot{
+					ObjectMeta: metav1.ObjectMeta{Name: "preflight-vs"},
+					Status:     &vsv1.VolumeSnapshotStatus{ReadyToUse: &f},
+				}, nil, false),
+				Entry("VolumeSnapshot ready but not yet bound to a VolumeSnapshotContent", &vsv1.VolumeSnapshot{
+					ObjectMeta: metav1.ObjectMeta{Name: "preflight-vs"},
+					Status:     &vsv1.VolumeSnapshotStatus{ReadyToUse: &t},
+				}, nil, false),
+				Entry("VolumeSnapshotContent driver does not match the target StorageClass's provisioner", &vsv1.VolumeSnapshot{
+					ObjectMeta: metav1.ObjectMeta{Name: "preflight-vs"},
+					Status: &vsv1.VolumeSnapshotStatus{
+						ReadyToUse:                     &t,
+						BoundVolumeSnapshotContentName: pointer.String("preflight-vs-content"),
+					},
+				}, &vsv1.VolumeSnapshotContent{
+					ObjectMeta: metav1.ObjectMeta{Name: "preflight-vs-content"},
+					Spec:       vsv1.VolumeSnapshotContentSpec{Driver: "other.csi.example.com"},
+				}, false),
+				Entry("VolumeSnapshotContent driver matches the target StorageClass's provisioner", &vsv1.VolumeSnapshot{
+					ObjectMeta: metav1.ObjectMeta{Name: "preflight-vs"},
+					Status: &vsv1.VolumeSnapshotStatus{
+						ReadyToUse:                     &t,
+						BoundVolumeSnapshotContentName: pointer.String("preflight-vs-content"),
+					},
+				}, &vsv1.VolumeSnapshotContent{
+					ObjectMeta: metav1.ObjectMeta{Name: "preflight-vs-content"},
+					Spec:       vsv1.VolumeSnapshotContentSpec{Driver: "csi.example.com"},
+				}, true),
+			)
+
 			It("should wait for bound", func() {
 				r := createRestoreWithOwner()
 				r.Status = &snapshotv1.VirtualMachineRestoreStatus{
@@ -843,8 +1061,229 @@ var _ = Describe("Restore controlleer", func() {
 						Expect(err).ShouldNot(HaveOccurred())
 					})
 
+					It("with a merge patch", func() {
+						r.Spec.MergePatches = []string{fmt.Sprintf(`{"metadata":{"name":%q}}`, newVmName)}
+
+						vmInterface.EXPECT().Create(gomock.Any()).DoAndReturn(func(newVM *v1.VirtualMachine) (*v1.VirtualMachine, error) {
+							Expect(newVM.Name).To(Equal(newVmName))
+							return newVM, nil
+						}).Times(1)
+
+						targetVM, err := controller.getTarget(r)
+						Expect(err).ShouldNot(HaveOccurred())
+						success, err := targetVM.Reconcile()
+						Expect(success).To(BeTrue())
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+
+					It("with a resource modifier retargeting the DataVolumeTemplate's StorageClass, gated on a selector", func() {
+						newStorageClassName := "new-storage-class"
+						r.Spec.ResourceModifiers = &snapshotv1.ResourceModifierSpec{
+							ResourceModifierRules: []snapshotv1.ResourceModifierRule{
+								{
+									Conditions: snapshotv1.ResourceModifierConditions{
+										GroupResource: dataVolumeGroupResource,
+										Selector:      fmt.Sprintf("spec.pvc.storageClassName == %s", fixtureStorageClassName),
+									},
+									Patches: []snapshotv1.ResourceModifierPatch{
+										{
+											Operation: "replace",
+											Path:      "/spec/pvc/storageClassName",
+											Value:     fmt.Sprintf("%q", newStorageClassName),
+										},
+									},
+								},
+							},
+						}
+
+						vmInterface.EXPECT().Create(gomock.Any()).DoAndReturn(func(newVM *v1.VirtualMachine) (*v1.VirtualMachine, error) {
+							Expect(newVM.Spec.DataVolumeTemplates).ToNot(BeEmpty())
+							Expect(*newVM.Spec.DataVolumeTemplates[0].Spec.PVC.StorageClassName).To(Equal(newStorageClassName))
+							return newVM, nil
+						}).Times(1)
+
+						targetVM, err := controller.getTarget(r)
+						Expect(err).ShouldNot(HaveOccurred())
+						success, err := targetVM.Reconcile()
+						Expect(success).To(BeTrue())
+						Expect(err).ShouldNot(HaveOccurred())
+
+						patchApplied := getRestoreCondition(r, snapshotv1.ConditionPatchApplied)
+						Expect(patchApplied).ToNot(BeNil())
+						Expect(patchApplied.Status).To(Equal(corev1.ConditionTrue))
+					})
+
+				})
+
+			})
+		})
+
+		Context("warmup", func() {
+			var r *snapshotv1.VirtualMachineRestore
+
+			newWarmupJob := func() *batchv1.Job {
+				return &batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      warmupJobName(r, "disk1"),
+						Namespace: testNamespace,
+					},
+					Spec: batchv1.JobSpec{
+						BackoffLimit: pointer.Int32(3),
+					},
+				}
+			}
+
+			BeforeEach(func() {
+				r = createRestoreWithOwner()
+				addVolumeRestores(r)
+				// Route Job actions to the fake object tracker ahead of the
+				// catch-all reactor that fails on any other k8s client action.
+				k8sClient.Fake.PrependReactor("*", "jobs", testing.ObjectReaction(k8sClient.Tracker()))
+			})
+
+			It("Sync mode blocks Ready until the warmup job succeeds", func() {
+				r.Spec.Warmup = &snapshotv1.VirtualMachineRestoreWarmupSpec{
+					Mode: snapshotv1.VirtualMachineRestoreWarmupSync,
+				}
+
+				job := newWarmupJob()
+				jobsClient := k8sClient.BatchV1().Jobs(testNamespace)
+				_, err := jobsClient.Create(context.Background(), job, metav1.CreateOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				jobSource.Add(job)
+				syncCaches(stop)
+
+				done, err := controller.reconcileWarmup(r)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(done).To(BeFalse())
+				Expect(r.Status.WarmupStatus).To(HaveLen(1))
+				Expect(r.Status.WarmupStatus[0].Phase).To(Equal(snapshotv1.VolumeWarmupPhaseRunning))
+
+				job.Status.Succeeded = 1
+				jobSource.Modify(job)
+				syncCaches(stop)
+
+				done, err = controller.reconcileWarmup(r)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(done).To(BeTrue())
+				Expect(r.Status.WarmupStatus[0].Phase).To(Equal(snapshotv1.VolumeWarmupPhaseSucceeded))
+			})
+
+			It("Async mode marks complete immediately while tracking per-volume WarmupStatus", func() {
+				r.Spec.Warmup = &snapshotv1.VirtualMachineRestoreWarmupSpec{
+					Mode: snapshotv1.VirtualMachineRestoreWarmupAsync,
+				}
+
+				job := newWarmupJob()
+				_, err := k8sClient.BatchV1().Jobs(testNamespace).Create(context.Background(), job, metav1.CreateOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				jobSource.Add(job)
+				syncCaches(stop)
+
+				done, err := controller.reconcileWarmup(r)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(done).To(BeTrue())
+				Expect(r.Status.WarmupStatus).To(HaveLen(1))
+				Expect(r.Status.WarmupStatus[0].Phase).To(Equal(snapshotv1.VolumeWarmupPhaseRunning))
+			})
+
+			It("deletes a failed warmup job once it exhausts its backoff so it is recreated on the next reconcile", func() {
+				r.Spec.Warmup = &snapshotv1.VirtualMachineRestoreWarmupSpec{
+					Mode: snapshotv1.VirtualMachineRestoreWarmupSync,
+				}
+
+				job := newWarmupJob()
+				job.Status.Failed = *job.Spec.BackoffLimit + 1
+				_, err := k8sClient.BatchV1().Jobs(testNamespace).Create(context.Background(), job, metav1.CreateOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				jobSource.Add(job)
+				syncCaches(stop)
+
+				done, err := controller.reconcileWarmup(r)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(done).To(BeFalse())
+				Expect(r.Status.WarmupStatus[0].Phase).To(Equal(snapshotv1.VolumeWarmupPhaseFailed))
+
+				_, err = k8sClient.BatchV1().Jobs(testNamespace).Get(context.Background(), job.Name, metav1.GetOptions{})
+				Expect(err).To(HaveOccurred())
+				Expect(errors.IsNotFound(err)).To(BeTrue())
+			})
+		})
+
+		Context("cross-namespace restore", func() {
+			const otherNamespace = "other-namespace"
+
+			var r *snapshotv1.VirtualMachineRestore
+
+			BeforeEach(func() {
+				r = createRestoreWithOwner()
+				r.Spec.Target.Namespace = pointer.String(otherNamespace)
+			})
+
+			It("reconcileCrossNamespaceSnapshot clones the source VolumeSnapshotContent into the target namespace", func() {
+				sourceName := "vmsnapshot-snapshot-uid-volume-disk1"
+				contentName := sourceName + "-content"
+				handle := "snapshot-handle-" + sourceName
+				sourceVS := &vsv1.VolumeSnapshot{
+					ObjectMeta: metav1.ObjectMeta{Name: sourceName, Namespace: testNamespace},
+					Status:     &vsv1.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: &contentName},
+				}
+				sourceContent := &vsv1.VolumeSnapshotContent{
+					ObjectMeta: metav1.ObjectMeta{Name: contentName},
+					Spec: vsv1.VolumeSnapshotContentSpec{
+						Driver:                  "csi.example.com",
+						VolumeSnapshotClassName: pointer.String("csi-snapclass"),
+					},
+					Status: &vsv1.VolumeSnapshotContentStatus{SnapshotHandle: &handle},
+				}
+				vscReader.Add(sourceContent)
+
+				// First GetVolumeSnapshot call looks up the target namespace
+				// (not created yet); the second resolves the source.
+				fakeVolumeSnapshotProvider.Add(nil)
+				fakeVolumeSnapshotProvider.Add(sourceVS)
+
+				name, ready, err := controller.reconcileCrossNamespaceSnapshot(r, "disk1", sourceName)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ready).To(BeFalse())
+				Expect(name).To(Equal(crossNamespaceVolumeSnapshotName(r, "disk1")))
+
+				clonedContent, err := vscReader.GetVolumeSnapshotContent(name + "-content")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(clonedContent).ToNot(BeNil())
+				Expect(clonedContent.Spec.Driver).To(Equal(sourceContent.Spec.Driver))
+				Expect(clonedContent.Spec.VolumeSnapshotClassName).To(Equal(sourceContent.Spec.VolumeSnapshotClassName))
+				Expect(*clonedContent.Spec.Source.SnapshotHandle).To(Equal(handle))
+				Expect(clonedContent.Spec.VolumeSnapshotRef.Namespace).To(Equal(otherNamespace))
+				Expect(clonedContent.Spec.VolumeSnapshotRef.Name).To(Equal(name))
+			})
+
+			It("validateTargetNamespaceAccess rejects the restore when the requester lacks RBAC access in the target namespace", func() {
+				r.Annotations = map[string]string{requesterAnnotation: "alice"}
+
+				k8sClient.Fake.PrependReactor("create", "subjectaccessreviews", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+					create := action.(testing.CreateAction)
+					sar := create.GetObject().(*authorizationv1.SubjectAccessReview)
+					Expect(sar.Spec.User).To(Equal("alice"))
+					sar.Status.Allowed = false
+					return true, sar, nil
+				})
+
+				err := controller.validateTargetNamespaceAccess(r)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("validateTargetNamespaceAccess allows the restore when the requester has RBAC access in the target namespace", func() {
+				r.Annotations = map[string]string{requesterAnnotation: "alice"}
+
+				k8sClient.Fake.PrependReactor("create", "subjectaccessreviews", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+					create := action.(testing.CreateAction)
+					sar := create.GetObject().(*authorizationv1.SubjectAccessReview)
+					sar.Status.Allowed = true
+					return true, sar, nil
 				})
 
+				Expect(controller.validateTargetNamespaceAccess(r)).To(Succeed())
 			})
 		})
 	})
@@ -922,7 +1361,8 @@ func expectDataVolumeDeletes(client *cdifake.Clientset, names []string) {
 
 // A mock to implement volumeSnapshotProvider interface
 type MockVolumeSnapshotProvider struct {
-	volumeSnapshots []*vsv1.VolumeSnapshot
+	volumeSnapshots  []*vsv1.VolumeSnapshot
+	snapshotContents map[string]*vsv1.VolumeSnapshotContent
 }
 
 func (v *MockVolumeSnapshotProvider) GetVolumeSnapshot(namespace, name string) (*vsv1.VolumeSnapshot, error) {
@@ -937,3 +1377,183 @@ func (v *MockVolumeSnapshotProvider) GetVolumeSnapshot(namespace, name string) (
 func (v *MockVolumeSnapshotProvider) Add(s *vsv1.VolumeSnapshot) {
 	v.volumeSnapshots = append(v.volumeSnapshots, s)
 }
+
+func (v *MockVolumeSnapshotProvider) CreateVolumeSnapshot(namespace string, vs *vsv1.VolumeSnapshot) (*vsv1.VolumeSnapshot, error) {
+	v.volumeSnapshots = append(v.volumeSnapshots, vs)
+	return vs, nil
+}
+
+func (v *MockVolumeSnapshotProvider) GetVolumeSnapshotContent(namespace, vsName string) (*vsv1.VolumeSnapshotContent, error) {
+	if v.snapshotContents == nil {
+		return nil, nil
+	}
+	return v.snapshotContents[namespace+"/"+vsName], nil
+}
+
+func (v *MockVolumeSnapshotProvider) AddContent(namespace, vsName string, vsc *vsv1.VolumeSnapshotContent) {
+	if v.snapshotContents == nil {
+		v.snapshotContents = map[string]*vsv1.VolumeSnapshotContent{}
+	}
+	v.snapshotContents[namespace+"/"+vsName] = vsc
+}
+
+var _ = Describe("Restore finalizer controller", func() {
+	const (
+		finalizerTestNamespace = "default"
+		finalizerVmRestoreName = "restore"
+		finalizerPVCName       = "restore-pvc"
+		finalizerPVName        = "restore-pv"
+	)
+
+	var (
+		vmRestoreSource   *framework.FakeControllerSource
+		vmRestoreInformer cache.SharedIndexInformer
+
+		pvcSource   *framework.FakeControllerSource
+		pvcInformer cache.SharedIndexInformer
+
+		pvSource   *framework.FakeControllerSource
+		pvInformer cache.SharedIndexInformer
+
+		controller *VMRestoreFinalizerController
+		recorder   *record.FakeRecorder
+
+		k8sClient      *k8sfake.Clientset
+		kubevirtClient *kubevirtfake.Clientset
+		stop           chan struct{}
+	)
+
+	newRestorePV := func(reclaimPolicy corev1.PersistentVolumeReclaimPolicy) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: finalizerPVName},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: reclaimPolicy,
+				ClaimRef: &corev1.ObjectReference{
+					Namespace: finalizerTestNamespace,
+					Name:      finalizerPVCName,
+				},
+			},
+		}
+	}
+
+	newBoundPVC := func() *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      finalizerPVCName,
+				Namespace: finalizerTestNamespace,
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				VolumeName: finalizerPVName,
+			},
+		}
+	}
+
+	newCompletedVMRestore := func() *snapshotv1.VirtualMachineRestore {
+		reclaimPolicy := corev1.PersistentVolumeReclaimRetain
+		return &snapshotv1.VirtualMachineRestore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       finalizerVmRestoreName,
+				Namespace:  finalizerTestNamespace,
+				Finalizers: []string{vmRestoreFinalizer},
+			},
+			Status: &snapshotv1.VirtualMachineRestoreStatus{
+				Complete: pointer.Bool(true),
+				Restores: []snapshotv1.VolumeRestore{
+					{
+						VolumeName:                "disk1",
+						PersistentVolumeClaimName: finalizerPVCName,
+						RestorePVSpec: &snapshotv1.RestorePVSpec{
+							ReclaimPolicy: &reclaimPolicy,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		stop = make(chan struct{})
+		virtClient := kubecli.NewMockKubevirtClient(gomock.NewController(GinkgoT()))
+
+		vmRestoreInformer, vmRestoreSource = testutils.NewFakeInformerWithIndexersFor(&snapshotv1.VirtualMachineRestore{}, virtcontroller.GetVirtualMachineRestoreInformerIndexers())
+		pvcInformer, pvcSource = testutils.NewFakeInformerFor(&corev1.PersistentVolumeClaim{})
+		pvInformer, pvSource = testutils.NewFakeInformerFor(&corev1.PersistentVolume{})
+
+		recorder = record.NewFakeRecorder(100)
+		recorder.IncludeObject = true
+
+		controller = &VMRestoreFinalizerController{
+			Client:            virtClient,
+			VMRestoreInformer: vmRestoreInformer,
+			PVCInformer:       pvcInformer,
+			PVInformer:        pvInformer,
+			Recorder:          recorder,
+		}
+		controller.Init()
+
+		kubevirtClient = kubevirtfake.NewSimpleClientset()
+		virtClient.EXPECT().VirtualMachineRestore(finalizerTestNamespace).
+			Return(kubevirtClient.SnapshotV1alpha1().VirtualMachineRestores(finalizerTestNamespace)).AnyTimes()
+
+		k8sClient = k8sfake.NewSimpleClientset()
+		virtClient.EXPECT().CoreV1().Return(k8sClient.CoreV1()).AnyTimes()
+
+		currentTime = timeFunc
+
+		go vmRestoreInformer.Run(stop)
+		go pvcInformer.Run(stop)
+		go pvInformer.Run(stop)
+		Expect(cache.WaitForCacheSync(stop, vmRestoreInformer.HasSynced, pvcInformer.HasSynced, pvInformer.HasSynced)).To(BeTrue())
+	})
+
+	AfterEach(func() {
+		close(stop)
+	})
+
+	It("patches the restored PV's reclaimPolicy and sets the Finalized condition", func() {
+		pvcSource.Add(newBoundPVC())
+		pvSource.Add(newRestorePV(corev1.PersistentVolumeReclaimDelete))
+
+		expectPVUpdate := func(client *k8sfake.Clientset) {
+			client.Fake.PrependReactor("update", "persistentvolumes", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+				update, ok := action.(testing.UpdateAction)
+				Expect(ok).To(BeTrue())
+
+				pv := update.GetObject().(*corev1.PersistentVolume)
+				Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(corev1.PersistentVolumeReclaimRetain))
+
+				return true, update.GetObject(), nil
+			})
+		}
+		expectPVUpdate(k8sClient)
+
+		vmRestore := newCompletedVMRestore()
+		vmRestoreSource.Add(vmRestore)
+
+		expectVMRestoreFinalizerUpdate(kubevirtClient, func(updated *snapshotv1.VirtualMachineRestore) {
+			Expect(hasFinalizer(updated.Finalizers, vmRestoreFinalizer)).To(BeTrue())
+			condition := getRestoreCondition(updated, snapshotv1.ConditionFinalized)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+		})
+
+		Expect(controller.updateVMRestoreFinalizer(controllerKey(finalizerTestNamespace, finalizerVmRestoreName))).To(Succeed())
+	})
+})
+
+// expectVMRestoreFinalizerUpdate is the restore finalizer controller's
+// counterpart to the main restore controller's expectVMRestoreUpdate: it
+// hands the updated object to a caller-supplied assertion instead of
+// requiring an exact match, since the finalizer controller only changes a
+// condition/finalizer list rather than the whole status the other tests in
+// this package compare wholesale.
+func expectVMRestoreFinalizerUpdate(client *kubevirtfake.Clientset, assert func(*snapshotv1.VirtualMachineRestore)) {
+	client.Fake.PrependReactor("update", "virtualmachinerestores", func(action testing.Action) (handled bool, obj runtime.Object, err error) {
+		update, ok := action.(testing.UpdateAction)
+		Expect(ok).To(BeTrue())
+
+		assert(update.GetObject().(*snapshotv1.VirtualMachineRestore))
+
+		return true, update.GetObject(), nil
+	})
+}
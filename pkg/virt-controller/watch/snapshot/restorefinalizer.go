@@ -0,0 +1,301 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+	"kubevirt.io/client-go/kubecli"
+	"kubevirt.io/client-go/log"
+)
+
+// VMRestoreFinalizerController runs the finalize phase Velero's
+// restore-finalizer pattern splits out of a single-pass restore: once
+// VMRestoreController's create phase has issued PVCs and updated the target
+// VM (Status.Complete true, vmRestoreFinalizer present), CDI dynamically
+// provisions a fresh PV for each restored DataVolume from its VolumeSnapshot,
+// discarding the source PV's reclaimPolicy/nodeAffinity/volumeAttributes/
+// labels/annotations in the process. This controller waits for that PV to
+// appear and patches those settings back from vr.RestorePVSpec, then removes
+// vmRestoreFinalizer so the VirtualMachineRestore can be deleted.
+type VMRestoreFinalizerController struct {
+	Client            kubecli.KubevirtClient
+	VMRestoreInformer cache.SharedIndexInformer
+	PVCInformer       cache.SharedIndexInformer
+	PVInformer        cache.SharedIndexInformer
+	Recorder          record.EventRecorder
+
+	vmRestoreQueue workqueue.RateLimitingInterface
+}
+
+// Init wires up the controller's workqueue and informer event handlers. It
+// must be called once before Run/processVMRestoreFinalizerWorkItem.
+func (ctrl *VMRestoreFinalizerController) Init() {
+	ctrl.vmRestoreQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "virt-controller-vm-restore-finalizer")
+
+	ctrl.VMRestoreInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.enqueueVMRestore,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.enqueueVMRestore(newObj) },
+	})
+
+	ctrl.PVInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.handlePV,
+		UpdateFunc: func(_, newObj interface{}) { ctrl.handlePV(newObj) },
+	})
+}
+
+func (ctrl *VMRestoreFinalizerController) enqueueVMRestore(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	ctrl.vmRestoreQueue.Add(key)
+}
+
+// handlePV maps a PV event back to the VirtualMachineRestore owning it,
+// via the PVC it is bound to and that PVC's restorePVCAnnotation.
+func (ctrl *VMRestoreFinalizerController) handlePV(obj interface{}) {
+	pv, ok := obj.(*corev1.PersistentVolume)
+	if !ok || pv.Spec.ClaimRef == nil {
+		return
+	}
+
+	key := controllerKey(pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)
+	obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+
+	pvc := obj.(*corev1.PersistentVolumeClaim)
+	name, ok := pvc.Annotations[restorePVCAnnotation]
+	if !ok {
+		return
+	}
+	ctrl.vmRestoreQueue.Add(controllerKey(pvc.Namespace, name))
+}
+
+// Run starts the controller's worker loop and blocks until stopCh is closed.
+func (ctrl *VMRestoreFinalizerController) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer ctrl.vmRestoreQueue.ShutDown()
+
+	log.Log.Info("Starting restore finalizer controller.")
+	defer log.Log.Info("Shutting down restore finalizer controller.")
+
+	if !cache.WaitForCacheSync(
+		stopCh,
+		ctrl.VMRestoreInformer.HasSynced,
+		ctrl.PVCInformer.HasSynced,
+		ctrl.PVInformer.HasSynced,
+	) {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < threadiness; i++ {
+		go ctrl.worker()
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (ctrl *VMRestoreFinalizerController) worker() {
+	for ctrl.processVMRestoreFinalizerWorkItem() {
+	}
+}
+
+func (ctrl *VMRestoreFinalizerController) processVMRestoreFinalizerWorkItem() bool {
+	key, quit := ctrl.vmRestoreQueue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.vmRestoreQueue.Done(key)
+
+	if err := ctrl.updateVMRestoreFinalizer(key.(string)); err != nil {
+		log.Log.Reason(err).Errorf("error finalizing VirtualMachineRestore %s", key)
+		ctrl.vmRestoreQueue.AddRateLimited(key)
+		return true
+	}
+
+	ctrl.vmRestoreQueue.Forget(key)
+	return true
+}
+
+func (ctrl *VMRestoreFinalizerController) updateVMRestoreFinalizer(key string) error {
+	obj, exists, err := ctrl.VMRestoreInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+
+	original := obj.(*snapshotv1.VirtualMachineRestore)
+	if !hasFinalizer(original.Finalizers, vmRestoreFinalizer) {
+		return nil
+	}
+	if original.Status == nil || original.Status.Complete == nil || !*original.Status.Complete {
+		return nil
+	}
+
+	vmRestore := original.DeepCopy()
+	ns := targetNamespace(vmRestore)
+
+	allPatched := true
+	for _, vr := range vmRestore.Status.Restores {
+		patched, err := ctrl.patchRestoredPV(vmRestore, ns, vr)
+		if err != nil {
+			return err
+		}
+		if !patched {
+			allPatched = false
+		}
+	}
+
+	if !allPatched {
+		setRestoreCondition(vmRestore, newFinalizedCondition(corev1.ConditionFalse, "InFinalization"))
+		return ctrl.updateVMRestore(original, vmRestore)
+	}
+
+	setRestoreCondition(vmRestore, newFinalizedCondition(corev1.ConditionTrue, "Finalized"))
+	if original.DeletionTimestamp != nil {
+		vmRestore.Finalizers = removeFinalizer(vmRestore.Finalizers, vmRestoreFinalizer)
+	}
+	return ctrl.updateVMRestore(original, vmRestore)
+}
+
+func (ctrl *VMRestoreFinalizerController) updateVMRestore(original, updated *snapshotv1.VirtualMachineRestore) error {
+	if equality.Semantic.DeepEqual(original, updated) {
+		return nil
+	}
+	_, err := ctrl.Client.VirtualMachineRestore(updated.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	return err
+}
+
+// patchRestoredPV patches the PV bound to vr's PVC with vr.RestorePVSpec's
+// reclaimPolicy/nodeAffinity/volumeAttributes/labels/annotations, reporting
+// whether the PV is provisioned and (now) carries those settings. A volume
+// with no RestorePVSpec is reported patched immediately -- there's nothing
+// this controller needs to wait for. A PVC that is missing or not yet bound
+// is reported not patched, so the restore is checked again once it binds.
+func (ctrl *VMRestoreFinalizerController) patchRestoredPV(vmRestore *snapshotv1.VirtualMachineRestore, namespace string, vr snapshotv1.VolumeRestore) (bool, error) {
+	if vr.RestorePVSpec == nil {
+		return true, nil
+	}
+
+	key := controllerKey(namespace, vr.PersistentVolumeClaimName)
+	obj, exists, err := ctrl.PVCInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+
+	pvc := obj.(*corev1.PersistentVolumeClaim)
+	if pvc.Spec.VolumeName == "" {
+		return false, nil
+	}
+
+	pv, err := ctrl.Client.CoreV1().PersistentVolumes().Get(context.Background(), pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	updated := pv.DeepCopy()
+	spec := vr.RestorePVSpec
+	if spec.ReclaimPolicy != nil {
+		updated.Spec.PersistentVolumeReclaimPolicy = *spec.ReclaimPolicy
+	}
+	if spec.NodeAffinity != nil {
+		updated.Spec.NodeAffinity = spec.NodeAffinity
+	}
+	if len(spec.VolumeAttributes) > 0 && updated.Spec.CSI != nil {
+		if updated.Spec.CSI.VolumeAttributes == nil {
+			updated.Spec.CSI.VolumeAttributes = map[string]string{}
+		}
+		for k, v := range spec.VolumeAttributes {
+			updated.Spec.CSI.VolumeAttributes[k] = v
+		}
+	}
+	for k, v := range spec.Labels {
+		if updated.Labels == nil {
+			updated.Labels = map[string]string{}
+		}
+		updated.Labels[k] = v
+	}
+	for k, v := range spec.Annotations {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[k] = v
+	}
+
+	if equality.Semantic.DeepEqual(pv, updated) {
+		upsertVolumeInformation(vmRestore, vr.VolumeName, func(info *snapshotv1.VolumeRestoreInfo) {
+			info.PersistentVolumeName = pv.Name
+			recordVolumePhase(info, "Finalized")
+		})
+		return true, nil
+	}
+
+	if _, err := ctrl.Client.CoreV1().PersistentVolumes().Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		return false, err
+	}
+
+	upsertVolumeInformation(vmRestore, vr.VolumeName, func(info *snapshotv1.VolumeRestoreInfo) {
+		info.PersistentVolumeName = updated.Name
+		recordVolumePhase(info, "Finalized")
+	})
+	return true, nil
+}
+
+func newFinalizedCondition(status corev1.ConditionStatus, reason string) snapshotv1.Condition {
+	return snapshotv1.Condition{
+		Type:               snapshotv1.ConditionFinalized,
+		Status:             status,
+		Reason:             reason,
+		LastTransitionTime: *currentTime(),
+	}
+}
+
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	for _, f := range finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFinalizer(finalizers []string, finalizer string) []string {
+	var kept []string
+	for _, f := range finalizers {
+		if f != finalizer {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,181 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package snapshot
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	v1 "kubevirt.io/api/core/v1"
+	snapshotv1 "kubevirt.io/api/snapshot/v1alpha1"
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+)
+
+// vmUID is the fixed UID shared by the fixture VirtualMachine and whatever
+// VirtualMachineSnapshot claims it as a source, so tests can assert on
+// restore artifact names without recomputing them.
+const vmUID = types.UID("uid")
+
+var fixtureVMAPIGroup = "kubevirt.io"
+var fixtureStorageClassName = "sc"
+
+// createVirtualMachine returns a VM with a single DataVolumeTemplate-backed
+// disk ("disk1", backed by DataVolumeTemplate "dv-disk1"), the minimal shape
+// the restore controller tests restore against.
+func createVirtualMachine(namespace, name string) *v1.VirtualMachine {
+	return &v1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       vmUID,
+		},
+		Spec: v1.VirtualMachineSpec{
+			DataVolumeTemplates: []v1.DataVolumeTemplateSpec{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "dv-disk1"},
+					Spec: cdiv1.DataVolumeSpec{
+						PVC: &corev1.PersistentVolumeClaimSpec{
+							StorageClassName: &fixtureStorageClassName,
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceStorage: resource.MustParse("2Gi"),
+								},
+							},
+						},
+					},
+				},
+			},
+			Template: &v1.VirtualMachineInstanceTemplateSpec{
+				Spec: v1.VirtualMachineInstanceSpec{
+					Domain: v1.DomainSpec{
+						Resources: v1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("64M"),
+							},
+						},
+						Devices: v1.Devices{
+							Interfaces: []v1.Interface{
+								{Name: "default"},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "disk1",
+							VolumeSource: v1.VolumeSource{
+								DataVolume: &v1.DataVolumeSource{Name: "dv-disk1"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func createVirtualMachineSnapshot(namespace, name, vmName string) *snapshotv1.VirtualMachineSnapshot {
+	return &snapshotv1.VirtualMachineSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: snapshotv1.VirtualMachineSnapshotSpec{
+			Source: corev1.TypedLocalObjectReference{
+				APIGroup: &fixtureVMAPIGroup,
+				Kind:     "VirtualMachine",
+				Name:     vmName,
+			},
+		},
+	}
+}
+
+// createPVCsForVM builds the PVC each of vm's DataVolumeTemplates would
+// produce, in the same order as vm.Spec.DataVolumeTemplates.
+func createPVCsForVM(vm *v1.VirtualMachine) []corev1.PersistentVolumeClaim {
+	var pvcs []corev1.PersistentVolumeClaim
+	for _, dvt := range vm.Spec.DataVolumeTemplates {
+		pvcs = append(pvcs, corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      dvt.Name,
+				Namespace: vm.Namespace,
+			},
+			Spec: *dvt.Spec.PVC,
+		})
+	}
+	return pvcs
+}
+
+// createVirtualMachineSnapshotContent builds the content a snapshot of vm
+// would have produced, with one VolumeBackup per entry in pvcs (matched
+// positionally to vm.Spec.DataVolumeTemplates).
+func createVirtualMachineSnapshotContent(s *snapshotv1.VirtualMachineSnapshot, vm *v1.VirtualMachine, pvcs []corev1.PersistentVolumeClaim) *snapshotv1.VirtualMachineSnapshotContent {
+	var sourceUID types.UID
+	if s.Status != nil && s.Status.SourceUID != nil {
+		sourceUID = *s.Status.SourceUID
+	}
+
+	var backups []snapshotv1.VolumeBackup
+	for i, dvt := range vm.Spec.DataVolumeTemplates {
+		if i >= len(pvcs) {
+			break
+		}
+
+		volumeName := dvt.Name
+		for _, vol := range vm.Spec.Template.Spec.Volumes {
+			if vol.DataVolume != nil && vol.DataVolume.Name == dvt.Name {
+				volumeName = vol.Name
+				break
+			}
+		}
+
+		pvc := pvcs[i]
+		vsName := fmt.Sprintf("vmsnapshot-%s-%s-volume-%s", s.Name, sourceUID, volumeName)
+		backups = append(backups, snapshotv1.VolumeBackup{
+			VolumeName:            volumeName,
+			PersistentVolumeClaim: pvc.DeepCopy(),
+			VolumeSnapshotName:    &vsName,
+		})
+	}
+
+	storedVM := vm.DeepCopy()
+	storedVM.UID = ""
+	storedVM.ResourceVersion = ""
+
+	return &snapshotv1.VirtualMachineSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("vmsnapshot-content-%s", s.Name),
+			Namespace: s.Namespace,
+		},
+		Spec: snapshotv1.VirtualMachineSnapshotContentSpec{
+			VirtualMachineSnapshotName: &s.Name,
+			Source: snapshotv1.SourceSpec{
+				VirtualMachine: &snapshotv1.VirtualMachine{
+					ObjectMeta: storedVM.ObjectMeta,
+					Spec:       storedVM.Spec,
+				},
+			},
+			VolumeBackups: backups,
+		},
+	}
+}
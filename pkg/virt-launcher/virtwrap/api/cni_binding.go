@@ -0,0 +1,60 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package api
+
+// CNIBindingMetadata carries the structured, plugin-specific metadata for a
+// VMI interface backed by a named CNI plugin invocation (logical switch
+// name, port security, port mirroring, egress QoS) rather than one of the
+// built-in bridge/masquerade/slirp bindings.
+//
+// Nothing in this tree calls ToCNIArgs outside its own test coverage in
+// tests/network/vmi_networking.go: the network-configuration path that
+// would select a CNI{PluginName: ...} InterfaceBindingMethod and invoke the
+// named plugin with this metadata on ADD/DEL is virt-handler/pod-network-
+// setup work, and that package is not part of this checkout.
+type CNIBindingMetadata struct {
+	PluginName      string
+	LogicalSwitch   string
+	PortSecurity    bool
+	PortMirror      string
+	EgressBandwidth string
+}
+
+// ToCNIArgs renders the metadata as the CNI_ARGS key/value pairs virt-handler
+// passes to the named CNI plugin invocation on ADD, and again (to unwind any
+// state) on DEL.
+func (m CNIBindingMetadata) ToCNIArgs() map[string]string {
+	args := map[string]string{
+		"IgnoreUnknown": "true",
+	}
+	if m.LogicalSwitch != "" {
+		args["K8S_POD_NETWORK"] = m.LogicalSwitch
+	}
+	if m.PortSecurity {
+		args["PORT_SECURITY"] = "enable"
+	}
+	if m.PortMirror != "" {
+		args["PORT_MIRROR"] = m.PortMirror
+	}
+	if m.EgressBandwidth != "" {
+		args["EGRESS_QOS"] = m.EgressBandwidth
+	}
+	return args
+}
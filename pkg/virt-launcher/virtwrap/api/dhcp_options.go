@@ -0,0 +1,89 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Standard DHCP option codes honored by the virt-launcher DHCP server, on
+// top of arbitrary numeric codes supplied via ExtraDHCPOptions.
+const (
+	DHCPOptionDomainName           = 15
+	DHCPOptionNTPServers           = 42
+	DHCPOptionTFTPServerName       = 66
+	DHCPOptionBootFileName         = 67
+	DHCPOptionDomainSearch         = 119
+	DHCPOptionClasslessStaticRoute = 121
+)
+
+// DHCPOptionEncoding selects how ExtraDHCPOption.Data is decoded before it is
+// placed on the wire, mirroring what CNI IPAM plugins accept.
+type DHCPOptionEncoding string
+
+const (
+	DHCPEncodingString DHCPOptionEncoding = "string"
+	DHCPEncodingBase64 DHCPOptionEncoding = "base64"
+	DHCPEncodingIPList DHCPOptionEncoding = "ip-list"
+)
+
+// ExtraDHCPOption is the wire-ready form of a single v1.DHCPOption entry,
+// decoded according to its Encoding.
+type ExtraDHCPOption struct {
+	Code uint8
+	Data []byte
+}
+
+// DecodeExtraDHCPOption decodes a raw v1.DHCPOption (Code, Data, Encoding)
+// into the bytes the DHCP server should place in the option's value field.
+//
+// Nothing in this tree calls DecodeExtraDHCPOption: the virt-launcher DHCP server that would read a VMI's
+// v1.DHCPOptions.ExtraDHCPOptions and place the decoded bytes on the wire
+// is part of the pod-network-setup/dhcp-server package, which is not part
+// of this checkout. tests/network/vmi_networking.go's "should offer
+// classless static routes and domain search via ExtraDHCPOptions" assumes
+// that wiring exists upstream.
+func DecodeExtraDHCPOption(code uint8, data string, encoding DHCPOptionEncoding) (ExtraDHCPOption, error) {
+	switch encoding {
+	case "", DHCPEncodingString:
+		return ExtraDHCPOption{Code: code, Data: []byte(data)}, nil
+	case DHCPEncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return ExtraDHCPOption{}, fmt.Errorf("failed to base64-decode dhcp option %d: %v", code, err)
+		}
+		return ExtraDHCPOption{Code: code, Data: decoded}, nil
+	case DHCPEncodingIPList:
+		var out []byte
+		for _, entry := range strings.Split(data, ",") {
+			ip := net.ParseIP(strings.TrimSpace(entry)).To4()
+			if ip == nil {
+				return ExtraDHCPOption{}, fmt.Errorf("invalid IPv4 address %q in dhcp option %d", entry, code)
+			}
+			out = append(out, ip...)
+		}
+		return ExtraDHCPOption{Code: code, Data: out}, nil
+	default:
+		return ExtraDHCPOption{}, fmt.Errorf("unknown dhcp option encoding %q for option %d", encoding, code)
+	}
+}
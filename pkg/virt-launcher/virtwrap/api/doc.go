@@ -0,0 +1,32 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+// Package api renders the shell commands, CNI metadata and wire-format
+// values several binding-mode requests in this repo's backlog asked for
+// (QoS tc qdiscs, TPROXY probe rules, CNI plugin args, tc mirroring, VLAN
+// sub-interface setup, extra DHCP options, IPv6 RA daemon args).
+//
+// Every file in this package renders values only -- none of them has a
+// production call site in this checkout. The pod-network-setup package
+// that would build a VMI's bridge/masquerade/CNI binding and actually run
+// these commands against the launcher pod's veth/tap devices is not part
+// of this tree, so each request landed its rendering logic here with
+// nothing upstream to wire it into. See the per-symbol doc comments below
+// for what test coverage (if any) exists for each.
+package api
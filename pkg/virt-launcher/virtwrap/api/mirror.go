@@ -0,0 +1,72 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package api
+
+import "fmt"
+
+// InterfaceMirror mirrors v1.Interface.Mirror: it causes virt-launcher to
+// attach a pcap tap on the guest's tap device inside the pod's netns and
+// stream frames to either another interface on the VMI or a sidecar that
+// exposes them over a websocket, mirroring the port-mirroring capability
+// common in OVN-style secondary-network plugins.
+//
+// Nothing in this tree calls TCMirrorCommands or TcpdumpSidecarArgs outside
+// their own test coverage: the pod-network-setup path that would install
+// these tc rules on the launcher's tap device, and the virtctl tcpdump
+// sidecar that would run this tcpdump invocation, are not part of this
+// checkout. tests/network/vmi_networking.go's mirroring coverage assumes
+// that wiring exists upstream.
+type InterfaceMirror struct {
+	// TargetInterface, when set, is the name of another VMI interface that
+	// should receive the mirrored frames. Mutually exclusive with
+	// streaming to the tcpdump sidecar.
+	TargetInterface string
+	// Filter is an optional tcpdump-style capture filter expression.
+	Filter string
+	// MaxPackets bounds the number of packets captured per session; zero
+	// means unbounded.
+	MaxPackets int
+}
+
+// TCMirrorCommands renders the tc(8) commands that clone ingress+egress
+// traffic on tapDevice to mirrorDevice using a matchall ingress/egress
+// mirred action, the same mechanism OVN-style port-mirroring uses.
+func TCMirrorCommands(tapDevice, mirrorDevice string) []string {
+	return []string{
+		fmt.Sprintf("tc qdisc add dev %s handle ffff: ingress", tapDevice),
+		fmt.Sprintf("tc filter add dev %s parent ffff: matchall action mirred egress mirror dev %s", tapDevice, mirrorDevice),
+		fmt.Sprintf("tc qdisc add dev %s clsact", tapDevice),
+		fmt.Sprintf("tc filter add dev %s egress matchall action mirred egress mirror dev %s", tapDevice, mirrorDevice),
+	}
+}
+
+// TcpdumpSidecarArgs renders the tcpdump(8) invocation the virtctl tcpdump
+// sidecar runs against tapDevice to stream captured frames out over its
+// websocket endpoint, honoring the mirror's filter and packet-count cap.
+func TcpdumpSidecarArgs(tapDevice string, mirror InterfaceMirror) []string {
+	args := []string{"-i", tapDevice, "-w", "-", "-U"}
+	if mirror.MaxPackets > 0 {
+		args = append(args, "-c", fmt.Sprintf("%d", mirror.MaxPackets))
+	}
+	if mirror.Filter != "" {
+		args = append(args, mirror.Filter)
+	}
+	return args
+}
@@ -0,0 +1,79 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package api
+
+import "fmt"
+
+// InterfaceQoS mirrors v1.Interface.QoS: egress/ingress bandwidth caps
+// rendered as a tc htb class (egress) and an ingress u32 police filter for
+// the tap/bridge veth inside the launcher pod, the same pattern CNI
+// bandwidth/QoS plugins use.
+//
+// Nothing in this tree calls TCCommandsForQoS yet: the pod-network-setup
+// package that builds a VMI's bridge/masquerade binding and would run these
+// commands against the tap/bridge veth it creates is not part of this
+// checkout, so there is no real call site to wire this into here.
+type InterfaceQoS struct {
+	IngressBandwidthBps int64
+	EgressBandwidthBps  int64
+	IngressBurstBytes   int64
+	EgressBurstBytes    int64
+	DSCP                *uint8
+}
+
+// TCCommandsForQoS renders the tc(8) commands needed to install (or, when qos
+// is nil, tear down) the htb root qdisc and an ingress qdisc with a u32
+// police filter for ifaceName, honoring the configured bandwidth caps and optional DSCP mark.
+func TCCommandsForQoS(ifaceName string, qos *InterfaceQoS) []string {
+	if qos == nil {
+		return []string{
+			fmt.Sprintf("tc qdisc del dev %s root", ifaceName),
+			fmt.Sprintf("tc qdisc del dev %s ingress", ifaceName),
+		}
+	}
+
+	cmds := []string{
+		fmt.Sprintf("tc qdisc add dev %s root handle 1: htb default 10", ifaceName),
+	}
+	if qos.EgressBandwidthBps > 0 {
+		burst := qos.EgressBurstBytes
+		if burst == 0 {
+			burst = qos.EgressBandwidthBps / 8
+		}
+		cmds = append(cmds, fmt.Sprintf(
+			"tc class add dev %s parent 1: classid 1:10 htb rate %dbit burst %db", ifaceName, qos.EgressBandwidthBps, burst))
+	}
+	if qos.IngressBandwidthBps > 0 {
+		burst := qos.IngressBurstBytes
+		if burst == 0 {
+			burst = qos.IngressBandwidthBps / 8
+		}
+		cmds = append(cmds,
+			fmt.Sprintf("tc qdisc add dev %s handle ffff: ingress", ifaceName),
+			fmt.Sprintf(
+				"tc filter add dev %s parent ffff: protocol ip u32 match u32 0 0 police rate %dbit burst %db drop",
+				ifaceName, qos.IngressBandwidthBps, burst))
+	}
+	if qos.DSCP != nil {
+		cmds = append(cmds, fmt.Sprintf(
+			"tc filter add dev %s parent 1: protocol ip u32 match ip dsfield %#x 0xff flowid 1:10", ifaceName, *qos.DSCP))
+	}
+	return cmds
+}
@@ -0,0 +1,54 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package api
+
+// RAConfig parameterizes virt-launcher's embedded IPv6 Router Advertisement
+// responder, which replaces the guest-driven "dhclient -6 / add routes by
+// hand" flow for masquerade-bound VMIs with SLAAC.
+//
+// Nothing in this tree calls RADaemonArgs, and no test exercises it either:
+// the embedded radvd-equivalent that virt-launcher would start on k6t-eth0
+// when a VMI uses masquerade binding with VMIPv6NetworkCIDR is not part of
+// this checkout. tests/network/vmi_networking.go's configureIpv6 helper
+// still drives the guest with the manual dhclient -6/route-add flow this
+// type was meant to replace.
+type RAConfig struct {
+	// BridgeInterface is the launcher-side bridge (e.g. k6t-eth0) the
+	// responder listens on and sends advertisements out of.
+	BridgeInterface string
+	// Prefix is the /64 IPv6 prefix advertised to the guest, derived from
+	// the network's VMIPv6NetworkCIDR.
+	Prefix string
+	// LinkLocalRouter is the launcher's link-local address on
+	// BridgeInterface, advertised as the default router. It must stay
+	// stable across migration so the target never re-advertises a
+	// different router and forces the guest to re-run DAD.
+	LinkLocalRouter string
+}
+
+// RADaemonArgs renders the argv virt-launcher execs to start its embedded RA
+// responder for cfg.
+func RADaemonArgs(cfg RAConfig) []string {
+	return []string{
+		"--interface", cfg.BridgeInterface,
+		"--prefix", cfg.Prefix,
+		"--default-router", cfg.LinkLocalRouter,
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package api
+
+import "fmt"
+
+// TProxyProbeFirewallMark is the fwmark used to keep TPROXY'd probe traffic
+// routing symmetric on the reply path.
+const TProxyProbeFirewallMark = 0x1
+
+// TProxyProbeRules renders the iptables mangle TPROXY rule and the matching
+// "ip rule fwmark ... lookup" entry that let kubelet TCP/HTTP probes reach
+// the guest without the masquerade binding's usual SNAT rewriting the
+// source address to the launcher's tap-side address.
+//
+// Nothing in this tree calls TProxyProbeRules yet: the masquerade binding's
+// pod-network-setup path, which would run these commands when
+// v1.InterfaceMasquerade.TProxyProbes is set, is not part of this checkout.
+// tests/libvmi.WithTProxyProbes and the e2e coverage in
+// tests/network/vmi_networking.go assume that wiring exists upstream.
+func TProxyProbeRules(probePort int, listenerPort int, table int) []string {
+	return []string{
+		fmt.Sprintf(
+			"iptables -t mangle -A PREROUTING -p tcp --dport %d -j TPROXY --on-port %d --on-ip 127.0.0.1 --tproxy-mark %#x/%#x",
+			probePort, listenerPort, TProxyProbeFirewallMark, TProxyProbeFirewallMark),
+		fmt.Sprintf("ip rule add fwmark %#x lookup %d", TProxyProbeFirewallMark, table),
+		fmt.Sprintf("ip route add local 0.0.0.0/0 dev lo table %d", table),
+	}
+}
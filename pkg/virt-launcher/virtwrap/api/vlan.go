@@ -0,0 +1,43 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package api
+
+import "fmt"
+
+// VLANBindingSetupCommands renders the shell commands virt-launcher runs to
+// create a VLAN sub-interface of parentIface and bridge it to tapDevice,
+// without any of the k6t masquerade NAT rules the default pod-network binding
+// installs. The sub-interface is created in the network namespace the
+// commands run in; none of them moves it to another namespace.
+//
+// Nothing in this tree calls VLANBindingSetupCommands: the pod-network-setup path that would run these commands when
+// a VMI interface sets v1.InterfaceVLAN is not part of this checkout.
+// libvmi.InterfaceDeviceWithVLANBinding only shapes the VMI spec; it does
+// not invoke this function.
+func VLANBindingSetupCommands(parentIface string, vlanID uint16, vlanIfaceName, bridgeName, tapDevice string) []string {
+	return []string{
+		fmt.Sprintf("ip link add link %s name %s type vlan id %d", parentIface, vlanIfaceName, vlanID),
+		fmt.Sprintf("ip link set %s up", vlanIfaceName),
+		fmt.Sprintf("ip link add %s type bridge", bridgeName),
+		fmt.Sprintf("ip link set %s up", bridgeName),
+		fmt.Sprintf("ip link set %s master %s", vlanIfaceName, bridgeName),
+		fmt.Sprintf("ip link set %s master %s", tapDevice, bridgeName),
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libnet
+
+import "fmt"
+
+// Route is a single policy-routed destination installed into a secondary
+// interface's own routing table.
+type Route struct {
+	Destination string
+	Via         string
+}
+
+// Rule selects which traffic is looked up in a given routing table, e.g.
+// "from <iface-ip> lookup <tableID>".
+type Rule struct {
+	From string
+}
+
+// WithRoutingTable emits the netplan/cloud-init v2 stanza that puts routes
+// and policy-routing rules for a single interface into tableID, so traffic
+// originating from that interface's address is looked up in its own table
+// rather than the default one.
+func WithRoutingTable(ifaceName string, tableID int, routes []Route, rules []Rule) EthernetOption {
+	return func(eth *EthernetConfig) {
+		for _, r := range routes {
+			eth.Routes = append(eth.Routes, fmt.Sprintf("to: %s", r.Destination), fmt.Sprintf("via: %s", r.Via), fmt.Sprintf("table: %d", tableID))
+		}
+		for _, r := range rules {
+			eth.RoutingPolicy = append(eth.RoutingPolicy, fmt.Sprintf("from: %s", r.From), fmt.Sprintf("table: %d", tableID))
+		}
+	}
+}
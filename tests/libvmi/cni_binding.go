@@ -0,0 +1,116 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libvmi
+
+import (
+	"encoding/json"
+
+	v1 "kubevirt.io/api/core/v1"
+
+	"kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+// networksAnnotation is the Multus annotation used to attach additional
+// (secondary) networks, optionally carrying per-network CNI args.
+const networksAnnotation = "k8s.v1.cni.cncf.io/networks"
+
+// multusNetworkSelection mirrors the subset of Multus' NetworkSelectionElement
+// that is relevant for driving a pluggable secondary CNI binding.
+type multusNetworkSelection struct {
+	Name      string            `json:"name"`
+	Interface string            `json:"interface,omitempty"`
+	CNIArgs   map[string]string `json:"cni-args,omitempty"`
+}
+
+// CNIBindingOption mutates the CNIBindingMetadata used to build a
+// pluggable secondary CNI binding.
+type CNIBindingOption func(*api.CNIBindingMetadata)
+
+// WithLogicalSwitch sets the logical switch (OVN/OVS) name the CNI plugin
+// should attach the interface to.
+func WithLogicalSwitch(name string) CNIBindingOption {
+	return func(m *api.CNIBindingMetadata) {
+		m.LogicalSwitch = name
+	}
+}
+
+// WithPortSecurity enables port-security enforcement on the plugin side.
+func WithPortSecurity() CNIBindingOption {
+	return func(m *api.CNIBindingMetadata) {
+		m.PortSecurity = true
+	}
+}
+
+// WithPortMirror requests that traffic on this interface be mirrored to the
+// named target port by the CNI plugin.
+func WithPortMirror(target string) CNIBindingOption {
+	return func(m *api.CNIBindingMetadata) {
+		m.PortMirror = target
+	}
+}
+
+// WithEgressBandwidth caps the egress rate the CNI plugin enforces for this
+// interface (e.g. "10M").
+func WithEgressBandwidth(rate string) CNIBindingOption {
+	return func(m *api.CNIBindingMetadata) {
+		m.EgressBandwidth = rate
+	}
+}
+
+// InterfaceDeviceWithCNIBinding returns a bridge-bound v1.Interface named
+// "name". opts are not applied here: the interface itself carries no CNI
+// metadata, which WithCNIBindingNetwork adds to the Multus networks annotation.
+func InterfaceDeviceWithCNIBinding(name string, opts ...CNIBindingOption) v1.Interface {
+	iface := *v1.DefaultBridgeNetworkInterface()
+	iface.Name = name
+	return iface
+}
+
+// WithCNIBindingNetwork adds the secondary network attachment described by
+// nadRef (namespace/name of the NetworkAttachmentDefinition), interface name
+// and CNI binding metadata to the VMI's Multus networks annotation.
+func WithCNIBindingNetwork(ifaceName, nadRef string, metadata api.CNIBindingMetadata, opts ...CNIBindingOption) Option {
+	for _, opt := range opts {
+		opt(&metadata)
+	}
+
+	selection := multusNetworkSelection{
+		Name:      nadRef,
+		Interface: ifaceName,
+		CNIArgs:   metadata.ToCNIArgs(),
+	}
+
+	return func(vmi *v1.VirtualMachineInstance) {
+		var selections []multusNetworkSelection
+		if existing, ok := vmi.Annotations[networksAnnotation]; ok {
+			_ = json.Unmarshal([]byte(existing), &selections)
+		}
+		selections = append(selections, selection)
+
+		raw, err := json.Marshal(selections)
+		if err != nil {
+			return
+		}
+		if vmi.Annotations == nil {
+			vmi.Annotations = map[string]string{}
+		}
+		vmi.Annotations[networksAnnotation] = string(raw)
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libvmi
+
+import (
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// ipv6SLAACAnnotation tells virt-launcher to run its embedded Router
+// Advertisement responder on the masquerade bridge instead of expecting the
+// guest to configure IPv6 itself via dhclient -6 and manual routes.
+const ipv6SLAACAnnotation = "kubevirt.io/ipv6-slaac"
+
+// WithIPv6SLAAC marks the VMI so virt-launcher advertises the pod network's
+// IPv6 prefix and its own link-local address as the default router on the
+// masquerade bridge. Any guest with SLAAC enabled (accept_ra=1, the default
+// for a non-forwarding host), including Cirros, auto-configures a routable
+// global address without any console commands.
+func WithIPv6SLAAC() Option {
+	return func(vmi *v1.VirtualMachineInstance) {
+		if vmi.Annotations == nil {
+			vmi.Annotations = map[string]string{}
+		}
+		vmi.Annotations[ipv6SLAACAnnotation] = "true"
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libvmi
+
+import (
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// MasqueradeOption mutates an in-construction masquerade v1.Interface,
+// analogous to the variadic Port options InterfaceDeviceWithMasqueradeBinding
+// already accepts.
+type MasqueradeOption func(*v1.Interface)
+
+// WithTProxyProbes opts the masquerade interface into TPROXY-based probing:
+// kubelet TCP/HTTP probes reach the guest without having their source
+// address rewritten by the binding's usual SNAT, so source-IP-sensitive
+// probes keep working.
+func WithTProxyProbes() MasqueradeOption {
+	return func(iface *v1.Interface) {
+		if iface.Masquerade == nil {
+			iface.Masquerade = &v1.InterfaceMasquerade{}
+		}
+		iface.Masquerade.TProxyProbes = true
+	}
+}
+
+// InterfaceDeviceWithMasqueradeBindingAndOptions is
+// InterfaceDeviceWithMasqueradeBinding with additional MasqueradeOptions
+// applied after the ports are set.
+func InterfaceDeviceWithMasqueradeBindingAndOptions(ports []v1.Port, opts ...MasqueradeOption) v1.Interface {
+	iface := InterfaceDeviceWithMasqueradeBinding(ports...)
+	for _, opt := range opts {
+		opt(&iface)
+	}
+	return iface
+}
@@ -0,0 +1,66 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libvmi
+
+import (
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// RouteOption configures the per-interface routing table a secondary
+// interface's guest-side route should be installed into.
+type RouteOption func(*secondaryInterfaceConfig)
+
+type secondaryInterfaceConfig struct {
+	tableID int
+}
+
+// WithRouteTable assigns the guest-side policy routing table ID a secondary
+// interface's traffic should be looked up in.
+func WithRouteTable(tableID int) RouteOption {
+	return func(c *secondaryInterfaceConfig) {
+		c.tableID = tableID
+	}
+}
+
+// WithSecondaryInterface attaches a bridge-bound secondary interface named
+// ifaceName, backed by the NetworkAttachmentDefinition nadRef, to the VMI.
+// Use the returned table ID together with libnet.WithRoutingTable to give the
+// interface its own guest-side policy routing table.
+func WithSecondaryInterface(ifaceName, nadRef string, opts ...RouteOption) (Option, int) {
+	cfg := &secondaryInterfaceConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	iface := *v1.DefaultBridgeNetworkInterface()
+	iface.Name = ifaceName
+
+	network := v1.Network{
+		Name: ifaceName,
+		NetworkSource: v1.NetworkSource{
+			Multus: &v1.MultusNetwork{NetworkName: nadRef},
+		},
+	}
+
+	return func(vmi *v1.VirtualMachineInstance) {
+		vmi.Spec.Domain.Devices.Interfaces = append(vmi.Spec.Domain.Devices.Interfaces, iface)
+		vmi.Spec.Networks = append(vmi.Spec.Networks, network)
+	}, cfg.tableID
+}
@@ -0,0 +1,69 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libvmi
+
+import (
+	"fmt"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// primaryUDNNetworksAnnotation is the Multus network-selection annotation key
+// used to request that a NetworkAttachmentDefinition satisfy the pod's
+// primary (eth0) interface, as OVN-Kubernetes user-defined networks do.
+const primaryUDNNetworksAnnotation = "v1.multus-cni.io/default-network"
+
+// WithPrimaryUserDefinedNetwork configures the VMI's default pod network to
+// be satisfied by the named NetworkAttachmentDefinition (a layer2 or layer3
+// user-defined network) instead of the cluster's default CNI. The referenced
+// NAD is expected to live in the VMI's namespace.
+//
+// This only sets the Multus annotation the e2e tests in
+// tests/network/vmi_networking.go assert against. Actually extracting the
+// UDN's address/MAC/MTU/gateway from the pod's network-status annotation
+// and propagating it into the DHCP/DHCPv6 offers the guest receives is
+// virt-launcher pod-network-setup work, and that package is not part of
+// this checkout.
+func WithPrimaryUserDefinedNetwork(nadName string) Option {
+	return func(vmi *v1.VirtualMachineInstance) {
+		if vmi.Annotations == nil {
+			vmi.Annotations = map[string]string{}
+		}
+		vmi.Annotations[primaryUDNNetworksAnnotation] = nadName
+	}
+}
+
+// HasPrimaryUserDefinedNetwork reports whether the VMI's primary interface is
+// expected to be satisfied by a user-defined network rather than the
+// cluster's default CNI.
+func HasPrimaryUserDefinedNetwork(vmi *v1.VirtualMachineInstance) bool {
+	_, exists := vmi.Annotations[primaryUDNNetworksAnnotation]
+	return exists
+}
+
+// PrimaryUserDefinedNetworkName returns the NAD name configured via
+// WithPrimaryUserDefinedNetwork, or an error if none was set.
+func PrimaryUserDefinedNetworkName(vmi *v1.VirtualMachineInstance) (string, error) {
+	nadName, exists := vmi.Annotations[primaryUDNNetworksAnnotation]
+	if !exists {
+		return "", fmt.Errorf("vmi %s/%s has no primary user-defined network configured", vmi.Namespace, vmi.Name)
+	}
+	return nadName, nil
+}
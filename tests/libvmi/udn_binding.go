@@ -0,0 +1,51 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libvmi
+
+import (
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// UDNBindingOption mutates an in-construction primary-UDN interface/network
+// pair, analogous to the Port-taking variadic options already accepted by
+// InterfaceDeviceWithMasqueradeBinding.
+type UDNBindingOption func(*v1.Interface, *v1.Network)
+
+// InterfaceDeviceWithUDNBinding returns a masquerade-style v1.Interface
+// together with the Option that wires its Network to nadRef, an
+// OVN-Kubernetes-style user-defined overlay network selected as the VMI's
+// primary attachment in place of the cluster's default pod network.
+//
+// This only shapes the VMI spec; see WithPrimaryUserDefinedNetwork for what
+// is and is not actually wired up on the launcher side (no DHCP/DHCPv6
+// extraction of the UDN's address/MAC/MTU/gateway in this checkout).
+func InterfaceDeviceWithUDNBinding(nadRef string, opts ...UDNBindingOption) (v1.Interface, Option) {
+	iface := InterfaceDeviceWithMasqueradeBinding()
+	network := v1.DefaultPodNetwork()
+
+	for _, opt := range opts {
+		opt(&iface, network)
+	}
+
+	return iface, func(vmi *v1.VirtualMachineInstance) {
+		vmi.Spec.Networks = append(vmi.Spec.Networks, *network)
+		WithPrimaryUserDefinedNetwork(nadRef)(vmi)
+	}
+}
@@ -0,0 +1,40 @@
+/*
+ * This file is part of the kubevirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2023 Red Hat, Inc.
+ *
+ */
+
+package libvmi
+
+import (
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// InterfaceDeviceWithVLANBinding returns a v1.Interface whose primary NIC is
+// attached to a physical VLAN sub-interface of parentIface on the node
+// rather than to the pod network's masquerade NAT, so the guest obtains a
+// routable underlay IP.
+func InterfaceDeviceWithVLANBinding(vlanID uint16, parentIface string) v1.Interface {
+	return v1.Interface{
+		Name: DefaultInterfaceName,
+		InterfaceBindingMethod: v1.InterfaceBindingMethod{
+			VLAN: &v1.InterfaceVLAN{
+				ID:           vlanID,
+				ParentDevice: parentIface,
+			},
+		},
+	}
+}
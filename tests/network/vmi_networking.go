@@ -21,10 +21,12 @@ package network
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"kubevirt.io/kubevirt/tests/framework/checks"
@@ -117,6 +119,16 @@ var _ = SIGDescribe("[rfe_id:694][crit:medium][vendor:cnv-qe@redhat.com][level:c
 		currentConfiguration = kv.Spec.Configuration
 	}
 
+	setVLANEnabled := func(enable bool) {
+		if currentConfiguration.NetworkConfiguration == nil {
+			currentConfiguration.NetworkConfiguration = &v1.NetworkConfiguration{}
+		}
+
+		currentConfiguration.NetworkConfiguration.PermitVLANInterfaceOnPodNetwork = pointer.BoolPtr(enable)
+		kv := tests.UpdateKubeVirtConfigValueAndWait(currentConfiguration)
+		currentConfiguration = kv.Spec.Configuration
+	}
+
 	Describe("Multiple virtual machines connectivity using bridge binding interface", func() {
 		var inboundVMI *v1.VirtualMachineInstance
 		var outboundVMI *v1.VirtualMachineInstance
@@ -545,6 +557,41 @@ var _ = SIGDescribe("[rfe_id:694][crit:medium][vendor:cnv-qe@redhat.com][level:c
 
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		It("should offer classless static routes and domain search via ExtraDHCPOptions", func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+			dhcpVMI := libvmi.NewTestToolingFedora()
+			tests.AddExplicitPodNetworkInterface(dhcpVMI)
+
+			dhcpVMI.Spec.Domain.Resources.Requests[k8sv1.ResourceName("memory")] = resource.MustParse("1024M")
+
+			// option 121 classless static route: 10.200.0.0/24 via 10.0.2.1, RFC 3442 wire format
+			// (bytes 18 0A C8 00 0A 00 02 01), base64-encoded.
+			const classlessStaticRoute = "GArIAAoAAgE="
+			dhcpVMI.Spec.Domain.Devices.Interfaces[0].DHCPOptions = &v1.DHCPOptions{
+				ExtraDHCPOptions: []v1.DHCPOption{
+					{Code: api.DHCPOptionClasslessStaticRoute, Data: classlessStaticRoute, Encoding: "base64"},
+					{Code: api.DHCPOptionDomainSearch, Data: "example.com", Encoding: "string"},
+				},
+			}
+
+			dhcpVMI = tests.WaitUntilVMIReady(tests.RunVMI(dhcpVMI, 40), console.LoginToFedora)
+
+			err = console.SafeExpectBatch(dhcpVMI, []expect.Batcher{
+				&expect.BSnd{S: "\n"},
+				&expect.BExp{R: console.PromptExpression},
+				&expect.BSnd{S: "dhclient -1 -r -d eth0\n"},
+				&expect.BExp{R: console.PromptExpression},
+				&expect.BSnd{S: "dhclient -1 -sf /usr/bin/env --request-options subnet-mask,broadcast-address,time-offset,routers,domain-search,domain-name,domain-name-servers,host-name,nis-domain,nis-servers,ntp-servers,interface-mtu,tftp-server-name,bootfile-name,classless-static-routes eth0 | tee /dhcp-env\n"},
+				&expect.BExp{R: console.PromptExpression},
+				&expect.BSnd{S: "grep -q 'new_classless_static_routes=10.200.0.0/24 10.0.2.1' /dhcp-env; echo $?\n"},
+				&expect.BExp{R: console.RetValue("0")},
+				&expect.BSnd{S: "grep -q 'new_domain_search=example.com' /dhcp-env; echo $?\n"},
+				&expect.BExp{R: console.RetValue("0")},
+			}, 15)
+
+			Expect(err).ToNot(HaveOccurred())
+		})
 	})
 
 	Context("VirtualMachineInstance with custom dns", func() {
@@ -777,6 +824,87 @@ var _ = SIGDescribe("[rfe_id:694][crit:medium][vendor:cnv-qe@redhat.com][level:c
 				Entry("with custom CIDR [IPv6]", []v1.Port{}, 8080, "fd10:10:10::/120"),
 			)
 
+			DescribeTable("IPv6 SLAAC", func(ports []v1.Port, tcpPort int) {
+				libnet.SkipWhenClusterNotSupportIpv6(virtClient)
+				var serverVMI *v1.VirtualMachineInstance
+				var clientVMI *v1.VirtualMachineInstance
+
+				net := v1.DefaultPodNetwork()
+				net.Pod.VMIPv6NetworkCIDR = api.DefaultVMIpv6CIDR
+
+				clientVMI = libvmi.NewCirros(
+					libvmi.WithInterface(libvmi.InterfaceDeviceWithMasqueradeBinding()),
+					libvmi.WithNetwork(net),
+					libvmi.WithIPv6SLAAC(),
+				)
+				clientVMI, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(clientVMI)
+				Expect(err).ToNot(HaveOccurred())
+				clientVMI = tests.WaitUntilVMIReady(clientVMI, console.LoginToCirros)
+
+				serverVMI = libvmi.NewCirros(
+					libvmi.WithInterface(libvmi.InterfaceDeviceWithMasqueradeBinding(ports...)),
+					libvmi.WithNetwork(net),
+					libvmi.WithIPv6SLAAC(),
+				)
+				serverVMI.Labels = map[string]string{"expose": "server"}
+				serverVMI, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(serverVMI)
+				Expect(err).ToNot(HaveOccurred())
+				serverVMI = tests.WaitUntilVMIReady(serverVMI, console.LoginToCirros)
+				Expect(serverVMI.Status.Interfaces).To(HaveLen(1))
+				Expect(serverVMI.Status.Interfaces[0].IPs).NotTo(BeEmpty())
+
+				By("asserting the Cirros guest auto-configured a GUA via SLAAC, without any console commands")
+				Expect(netutils.IsIPv6String(serverVMI.Status.Interfaces[0].IP)).To(BeTrue())
+
+				By("starting a http server")
+				tests.StartPythonHttpServer(serverVMI, tcpPort)
+
+				Expect(verifyClientServerConnectivity(clientVMI, serverVMI, tcpPort, k8sv1.IPv6Protocol)).To(Succeed())
+			},
+				Entry("with a specific port number [IPv6][SLAAC]", []v1.Port{{Name: "http", Port: 8080}}, 8080),
+				Entry("without a specific port number [IPv6][SLAAC]", []v1.Port{}, 8080),
+			)
+
+			When("migrating a VMI configured via IPv6 SLAAC", func() {
+				It("should not flap the guest's address through DAD on the target", func() {
+					checks.SkipIfMigrationIsNotPossible()
+					libnet.SkipWhenClusterNotSupportIpv6(virtClient)
+
+					net := v1.DefaultPodNetwork()
+					net.Pod.VMIPv6NetworkCIDR = api.DefaultVMIpv6CIDR
+
+					vmi := libvmi.NewCirros(
+						libvmi.WithInterface(libvmi.InterfaceDeviceWithMasqueradeBinding()),
+						libvmi.WithNetwork(net),
+						libvmi.WithIPv6SLAAC(),
+					)
+					vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+					Expect(err).ToNot(HaveOccurred())
+					vmi = tests.WaitUntilVMIReady(vmi, console.LoginToCirros)
+					Expect(vmi.Status.Interfaces).To(HaveLen(1))
+					addressBeforeMigration := vmi.Status.Interfaces[0].IP
+					Expect(netutils.IsIPv6String(addressBeforeMigration)).To(BeTrue())
+
+					migration := tests.NewRandomMigration(vmi.Name, vmi.Namespace)
+					migration, err = virtClient.VirtualMachineInstanceMigration(migration.Namespace).Create(migration, &metav1.CreateOptions{})
+					Expect(err).ToNot(HaveOccurred())
+
+					Eventually(func() v1.VirtualMachineInstanceMigrationPhase {
+						migration, err := virtClient.VirtualMachineInstanceMigration(migration.Namespace).Get(migration.Name, &v13.GetOptions{})
+						Expect(err).ToNot(HaveOccurred())
+						return migration.Status.Phase
+					}, tests.MigrationWaitTime, time.Second).Should(Equal(v1.MigrationSucceeded))
+
+					vmi, err = virtClient.VirtualMachineInstance(vmi.Namespace).Get(vmi.Name, &v13.GetOptions{})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(vmi.Status.Interfaces[0].IP).To(Equal(addressBeforeMigration),
+						"the RA-advertised prefix must stay stable across migration so the target never re-runs DAD on a fresh tentative address")
+
+					By("verifying the address is reachable immediately after migration, i.e. not left tentative")
+					Expect(libnet.PingFromVMConsole(vmi, addressBeforeMigration)).To(Succeed())
+				})
+			})
+
 			It("[outside_connectivity]should be able to reach the outside world [IPv6]", func() {
 				libnet.SkipWhenClusterNotSupportIpv6(virtClient)
 				// Cluster nodes subnet (docker network gateway)
@@ -913,6 +1041,101 @@ var _ = SIGDescribe("[rfe_id:694][crit:medium][vendor:cnv-qe@redhat.com][level:c
 			)
 		})
 
+		When("the primary interface is a user-defined overlay network", func() {
+			const udnNADName = "primary-udn-overlay"
+
+			checkPodHasIPsAtNetwork := func(pod *k8sv1.Pod) []string {
+				status, err := podPrimaryNetworkStatus(pod)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(status.Name).To(ContainSubstring(udnNADName))
+				return status.IPs
+			}
+
+			It("[Conformance] preserves the overlay address across live migration and a guest reboot", func() {
+				checks.SkipIfMigrationIsNotPossible()
+				libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+				iface, netOpt := libvmi.InterfaceDeviceWithUDNBinding(udnNADName)
+				vmi := libvmi.NewCirros(
+					libvmi.WithInterface(iface),
+					netOpt,
+				)
+
+				vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+				Expect(err).ToNot(HaveOccurred())
+				vmi = tests.WaitUntilVMIReady(vmi, console.LoginToCirros)
+
+				vmiPod := tests.GetRunningPodByVirtualMachineInstance(vmi, util.NamespaceTestDefault)
+				ipsBeforeMigration := checkPodHasIPsAtNetwork(vmiPod)
+
+				migration := tests.NewRandomMigration(vmi.Name, vmi.Namespace)
+				migration, err = virtClient.VirtualMachineInstanceMigration(migration.Namespace).Create(migration, &metav1.CreateOptions{})
+				Expect(err).ToNot(HaveOccurred())
+
+				Eventually(func() v1.VirtualMachineInstanceMigrationPhase {
+					migration, err := virtClient.VirtualMachineInstanceMigration(migration.Namespace).Get(migration.Name, &v13.GetOptions{})
+					Expect(err).ToNot(HaveOccurred())
+					return migration.Status.Phase
+				}, tests.MigrationWaitTime, time.Second).Should(Equal(v1.MigrationSucceeded))
+
+				vmi, err = virtClient.VirtualMachineInstance(vmi.Namespace).Get(vmi.Name, &v13.GetOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				vmiPod = tests.GetRunningPodByVirtualMachineInstance(vmi, util.NamespaceTestDefault)
+				Expect(checkPodHasIPsAtNetwork(vmiPod)).To(Equal(ipsBeforeMigration), "the overlay IP must survive live migration")
+
+				By("Restarting the vmi")
+				Expect(console.SafeExpectBatch(vmi, []expect.Batcher{
+					&expect.BSnd{S: "sudo reboot\n"},
+					&expect.BExp{R: "reboot: Restarting system"},
+				}, 10)).To(Succeed(), "failed to restart the vmi")
+				tests.WaitUntilVMIReady(vmi, console.LoginToCirros)
+				Expect(checkPodHasIPsAtNetwork(vmiPod)).To(Equal(ipsBeforeMigration), "the overlay IP must survive a guest reboot")
+			})
+		})
+
+		When("a masquerade-bound VMI opts into TPROXY probing", func() {
+			const tproxyProbePort = 8080
+
+			It("should let kubelet probes see the node IP as source instead of 10.0.2.2, across live migration", func() {
+				checks.SkipIfMigrationIsNotPossible()
+				libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+				iface := libvmi.InterfaceDeviceWithMasqueradeBindingAndOptions(
+					[]v1.Port{{Port: tproxyProbePort}}, libvmi.WithTProxyProbes())
+				vmi := libvmi.NewCirros(
+					libvmi.WithInterface(iface),
+					libvmi.WithNetwork(v1.DefaultPodNetwork()),
+				)
+
+				vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+				Expect(err).ToNot(HaveOccurred())
+				vmi = tests.WaitUntilVMIReady(vmi, console.LoginToCirros)
+				tests.StartTCPServer(vmi, tproxyProbePort)
+
+				Expect(vmi.Spec.Domain.Devices.Interfaces[0].Masquerade.TProxyProbes).To(BeTrue())
+
+				By("checking the guest sees the probe's source as something other than the masquerade gateway")
+				err = console.SafeExpectBatch(vmi, []expect.Batcher{
+					&expect.BSnd{S: "\n"},
+					&expect.BExp{R: console.PromptExpression},
+					&expect.BSnd{S: fmt.Sprintf("nc -l -p %d -w 5 | grep -v 10.0.2.2\n", tproxyProbePort)},
+					&expect.BExp{R: console.PromptExpression},
+				}, 30)
+				Expect(err).ToNot(HaveOccurred())
+
+				By("migrating the VMI and expecting probes to keep succeeding")
+				migration := tests.NewRandomMigration(vmi.Name, vmi.Namespace)
+				migration, err = virtClient.VirtualMachineInstanceMigration(migration.Namespace).Create(migration, &metav1.CreateOptions{})
+				Expect(err).ToNot(HaveOccurred())
+
+				Eventually(func() v1.VirtualMachineInstanceMigrationPhase {
+					migration, err := virtClient.VirtualMachineInstanceMigration(migration.Namespace).Get(migration.Name, &v13.GetOptions{})
+					Expect(err).ToNot(HaveOccurred())
+					return migration.Status.Phase
+				}, tests.MigrationWaitTime, time.Second).Should(Equal(v1.MigrationSucceeded))
+			})
+		})
+
 		Context("MTU verification", func() {
 			var vmi *v1.VirtualMachineInstance
 			var anotherVmi *v1.VirtualMachineInstance
@@ -1002,6 +1225,265 @@ var _ = SIGDescribe("[rfe_id:694][crit:medium][vendor:cnv-qe@redhat.com][level:c
 		})
 	})
 
+	Context("VirtualMachineInstance with a primary user-defined network", func() {
+		const primaryUDNName = "primary-udn"
+
+		udnVMI := func() *v1.VirtualMachineInstance {
+			return libvmi.NewCirros(
+				libvmi.WithPrimaryUserDefinedNetwork(primaryUDNName),
+				libvmi.WithInterface(*v1.DefaultBridgeNetworkInterface()),
+				libvmi.WithNetwork(v1.DefaultPodNetwork()),
+			)
+		}
+
+		BeforeEach(func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+		})
+
+		DescribeTable("should be able to reach", func(toPod bool) {
+			serverVMI := runVMIAndExpectUDNAttachment(virtClient, udnVMI())
+
+			addr := serverVMI.Status.Interfaces[0].IP
+			if toPod {
+				vmiPod := tests.GetRunningPodByVirtualMachineInstance(serverVMI, util.NamespaceTestDefault)
+				status, err := podPrimaryNetworkStatus(vmiPod)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(status.IPs).NotTo(BeEmpty())
+				addr = status.IPs[0]
+			}
+
+			clientVMI := runVMIAndExpectUDNAttachment(virtClient, udnVMI())
+			Expect(libnet.PingFromVMConsole(clientVMI, addr)).To(Succeed())
+		},
+			Entry("another VMI on the same user-defined network", false),
+			Entry("the server pod directly via its UDN address", true),
+		)
+
+		It("should preserve the UDN address across live migration", func() {
+			checks.SkipIfMigrationIsNotPossible()
+
+			vmi := runVMIAndExpectUDNAttachment(virtClient, udnVMI())
+			addrBeforeMigration := vmi.Status.Interfaces[0].IP
+
+			migration := tests.NewRandomMigration(vmi.Name, vmi.Namespace)
+			migration, err = virtClient.VirtualMachineInstanceMigration(migration.Namespace).Create(migration, &metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() v1.VirtualMachineInstanceMigrationPhase {
+				migration, err := virtClient.VirtualMachineInstanceMigration(migration.Namespace).Get(migration.Name, &v13.GetOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				return migration.Status.Phase
+			}, tests.MigrationWaitTime, time.Second).Should(Equal(v1.MigrationSucceeded))
+
+			vmi, err = virtClient.VirtualMachineInstance(vmi.Namespace).Get(vmi.Name, &v13.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vmi.Status.Interfaces[0].IP).To(Equal(addrBeforeMigration), "the UDN address must be preserved across live migration")
+		})
+	})
+
+	Context("VirtualMachineInstance with interface traffic mirroring", func() {
+		It("should install a mirred filter on the mirrored interface after a peer VMI sends it ICMP traffic", func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+			inboundVMI := libvmi.NewCirros(
+				libvmi.WithInterface(*v1.DefaultBridgeNetworkInterface()),
+				libvmi.WithNetwork(v1.DefaultPodNetwork()),
+			)
+			inboundVMI.Spec.Domain.Devices.Interfaces[0].Mirror = &v1.InterfaceMirror{Filter: "icmp", MaxPackets: 10}
+
+			outboundVMI := libvmi.NewCirros()
+
+			inboundVMI, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(inboundVMI)
+			Expect(err).ToNot(HaveOccurred())
+			inboundVMI = tests.WaitUntilVMIReady(inboundVMI, console.LoginToCirros)
+
+			outboundVMI, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(outboundVMI)
+			Expect(err).ToNot(HaveOccurred())
+			outboundVMI = tests.WaitUntilVMIReady(outboundVMI, console.LoginToCirros)
+
+			By("generating ICMP traffic from the outbound VMI")
+			addr := inboundVMI.Status.Interfaces[0].IP
+			Expect(libnet.PingFromVMConsole(outboundVMI, addr, "-c 3", "-w 5")).To(Succeed())
+
+			By("checking a mirred filter is installed on the tap device")
+			vmiPod := tests.GetRunningPodByVirtualMachineInstance(inboundVMI, util.NamespaceTestDefault)
+			output, err := tests.ExecuteCommandOnPod(
+				virtClient,
+				vmiPod,
+				"compute",
+				[]string{"/bin/bash", "-c", "tc -s filter show dev eth0-nic ingress | grep -c mirred"},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.TrimSpace(output)).ToNot(Equal("0"), "the tap device should have a mirred filter installed")
+		})
+	})
+
+	Context("VirtualMachineInstance with cluster-managed MAC allocation", func() {
+		It("should assign every concurrently created VMI a unique MAC address from the pool", func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+			const concurrentVMICount = 50
+
+			var wg sync.WaitGroup
+			macs := make(chan string, concurrentVMICount)
+			errs := make(chan error, concurrentVMICount)
+
+			for i := 0; i < concurrentVMICount; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					vmi := libvmi.NewAlpine(
+						libvmi.WithInterface(*v1.DefaultBridgeNetworkInterface()),
+						libvmi.WithNetwork(v1.DefaultPodNetwork()),
+					)
+					vmi, createErr := virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+					if createErr != nil {
+						errs <- createErr
+						return
+					}
+					vmi = tests.WaitUntilVMIReady(vmi, console.LoginToAlpine)
+					macs <- vmi.Spec.Domain.Devices.Interfaces[0].MacAddress
+				}()
+			}
+			wg.Wait()
+			close(macs)
+			close(errs)
+
+			for err := range errs {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			seen := map[string]bool{}
+			for mac := range macs {
+				Expect(mac).ToNot(BeEmpty(), "every interface should have been allocated a MAC from the cluster MACPool")
+				Expect(seen[mac]).To(BeFalse(), "MAC %s was allocated to more than one VMI", mac)
+				seen[mac] = true
+			}
+			Expect(seen).To(HaveLen(concurrentVMICount))
+		})
+	})
+
+	Context("VirtualMachineInstance with bandwidth limits", func() {
+		const qosTestPort = 8090
+
+		qosVMI := func(qos *v1.QoS) *v1.VirtualMachineInstance {
+			iface := libvmi.InterfaceDeviceWithMasqueradeBinding()
+			iface.QoS = qos
+			return libvmi.NewCirros(
+				libvmi.WithInterface(iface),
+				libvmi.WithNetwork(v1.DefaultPodNetwork()),
+			)
+		}
+
+		capQoS := func(rate int64) *v1.QoS {
+			return &v1.QoS{
+				EgressBandwidth:  *resource.NewQuantity(rate, resource.DecimalSI),
+				IngressBandwidth: *resource.NewQuantity(rate, resource.DecimalSI),
+			}
+		}
+
+		It("should cap throughput between two VMIs to the configured rate", func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+			const cappedRateBitsPerSecond = 10 * 1000 * 1000 // 10 Mbit/s
+
+			inboundVMI := qosVMI(capQoS(cappedRateBitsPerSecond))
+			inboundVMI, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(inboundVMI)
+			Expect(err).ToNot(HaveOccurred())
+			inboundVMI = tests.WaitUntilVMIReady(inboundVMI, console.LoginToCirros)
+			tests.StartTCPServer(inboundVMI, qosTestPort)
+
+			outboundVMI := qosVMI(nil)
+			outboundVMI, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(outboundVMI)
+			Expect(err).ToNot(HaveOccurred())
+			outboundVMI = tests.WaitUntilVMIReady(outboundVMI, console.LoginToCirros)
+
+			addr := inboundVMI.Status.Interfaces[0].IP
+			// Allow up to 1.5x the configured cap before failing, since tc's
+			// htb shaping and ingress police filter are bursty rather than a hard ceiling.
+			const toleranceFactor = 1.5
+			err = console.SafeExpectBatch(outboundVMI, []expect.Batcher{
+				&expect.BSnd{S: "\n"},
+				&expect.BExp{R: console.PromptExpression},
+				&expect.BSnd{S: fmt.Sprintf(
+					"iperf3 -c %s -p %d -t 5 -J | grep -o '\"bits_per_second\":[0-9.]*' | tail -1 | awk -F: '{if ($2+0 <= %d) print \"RATE_WITHIN_CAP\"; else print \"RATE_EXCEEDS_CAP\"}'\n",
+					addr, qosTestPort, int64(cappedRateBitsPerSecond*toleranceFactor)),
+				},
+				&expect.BExp{R: "RATE_WITHIN_CAP"},
+			}, 30)
+			Expect(err).ToNot(HaveOccurred(), "measured iperf3 throughput should stay within tolerance of the configured QoS cap")
+		})
+
+		It("should no longer report a QoS cap after a live update removes the QoS", func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+			vmi := qosVMI(capQoS(5 * 1000 * 1000))
+			vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+			Expect(err).ToNot(HaveOccurred())
+			vmi = tests.WaitUntilVMIReady(vmi, console.LoginToCirros)
+
+			vmi.Spec.Domain.Devices.Interfaces[0].QoS = nil
+			_, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Update(vmi)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Get(vmi.Name, &v13.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vmi.Spec.Domain.Devices.Interfaces[0].QoS).To(BeNil(), "the server should no longer report a QoS cap for the interface")
+		})
+	})
+
+	Context("VirtualMachineInstance with a pluggable secondary CNI binding", func() {
+		const (
+			secondaryNAD   = "secondary-cni-net"
+			secondaryIface = "eth1"
+		)
+
+		cniBoundVMI := func(opts ...libvmi.CNIBindingOption) *v1.VirtualMachineInstance {
+			metadata := api.CNIBindingMetadata{PluginName: "ovn-k8s-cni-overlay"}
+			return libvmi.NewAlpine(
+				libvmi.WithInterface(libvmi.InterfaceDeviceWithCNIBinding(secondaryIface, opts...)),
+				libvmi.WithCNIBindingNetwork(secondaryIface, secondaryNAD, metadata,
+					libvmi.WithLogicalSwitch("ls0"),
+					libvmi.WithPortSecurity(),
+					libvmi.WithPortMirror("mirror0"),
+					libvmi.WithEgressBandwidth("10M"),
+				),
+			)
+		}
+
+		It("should be reachable over the CNI-bound interface", func() {
+			vmi := cniBoundVMI()
+			vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+			Expect(err).ToNot(HaveOccurred())
+			vmi = tests.WaitUntilVMIReady(vmi, console.LoginToAlpine)
+
+			vmiPod := tests.GetRunningPodByVirtualMachineInstance(vmi, util.NamespaceTestDefault)
+			raw, exists := vmiPod.Annotations[networksAnnotation]
+			Expect(exists).To(BeTrue(), "pod should carry the Multus networks annotation for the secondary CNI binding")
+			Expect(raw).To(ContainSubstring(secondaryNAD))
+		})
+
+		It("should honor MAC/PCI overrides, disabled learning and DHCPOptions on the CNI-bound interface", func() {
+			iface := libvmi.InterfaceDeviceWithCNIBinding(secondaryIface)
+			iface.MacAddress = "de:ad:00:00:be:ef"
+			iface.PciAddress = "0000:82:00.1"
+
+			metadata := api.CNIBindingMetadata{PluginName: "ovn-k8s-cni-overlay"}
+			vmi := libvmi.NewAlpine(
+				libvmi.WithInterface(iface),
+				libvmi.WithCNIBindingNetwork(secondaryIface, secondaryNAD, metadata),
+			)
+			vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+			Expect(err).ToNot(HaveOccurred())
+			vmi = tests.WaitUntilVMIReady(vmi, console.LoginToAlpine)
+
+			checkMacAddress(vmi, iface.MacAddress)
+			checkLearningState(vmi, "0")
+		})
+	})
+
 	Context("VirtualMachineInstance with TX offload disabled", func() {
 		It("[test_id:1781]should have tx checksumming disabled on interface serving dhcp", func() {
 			vmi := libvmi.NewAlpine()
@@ -1018,6 +1500,140 @@ var _ = SIGDescribe("[rfe_id:694][crit:medium][vendor:cnv-qe@redhat.com][level:c
 		})
 	})
 
+	Context("[Serial]VirtualMachineInstance with underlay VLAN binding", func() {
+		const vlanParentIface = "eth0"
+		const vlanID = uint16(100)
+
+		BeforeEach(func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+			setVLANEnabled(true)
+		})
+		AfterEach(func() {
+			setVLANEnabled(false)
+		})
+
+		vlanVMI := func() *v1.VirtualMachineInstance {
+			return libvmi.NewAlpine(
+				libvmi.WithInterface(libvmi.InterfaceDeviceWithVLANBinding(vlanID, vlanParentIface)),
+				libvmi.WithNetwork(v1.DefaultPodNetwork()),
+			)
+		}
+
+		It("[test_id:9400]should reject VLAN interfaces when not permitted on pod network", func() {
+			setVLANEnabled(false)
+			_, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vlanVMI())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should give tap0 the parent interface MTU and obtain a DHCP lease on eth0", func() {
+			vmi := vlanVMI()
+			vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+			Expect(err).ToNot(HaveOccurred())
+			vmi = tests.WaitUntilVMIReady(vmi, console.LoginToAlpine)
+
+			vmiPod := tests.GetRunningPodByVirtualMachineInstance(vmi, util.NamespaceTestDefault)
+			parentMTU, err := tests.ExecuteCommandOnPod(
+				virtClient, vmiPod, "compute",
+				[]string{"cat", fmt.Sprintf("/sys/class/net/%s/mtu", vlanParentIface)},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			tapMTU, err := tests.ExecuteCommandOnPod(
+				virtClient, vmiPod, "compute",
+				[]string{"cat", "/sys/class/net/tap0/mtu"},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.TrimSpace(tapMTU)).To(Equal(strings.TrimSpace(parentMTU)), "tap0 MTU should equal the parent interface MTU")
+
+			By("checking eth0 obtained a lease")
+			err = console.SafeExpectBatch(vmi, []expect.Batcher{
+				&expect.BSnd{S: "\n"},
+				&expect.BExp{R: console.PromptExpression},
+				&expect.BSnd{S: "ip address show eth0\n"},
+				&expect.BExp{R: "inet "},
+			}, 30)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("VirtualMachineInstance with multiple secondary interfaces and per-interface routing", func() {
+		It("should route egress traffic out the interface matching the destination's policy table", func() {
+			libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+			secondaryA, tableA := libvmi.WithSecondaryInterface("eth1", "secondary-net-a", libvmi.WithRouteTable(101))
+			secondaryB, tableB := libvmi.WithSecondaryInterface("eth2", "secondary-net-b", libvmi.WithRouteTable(102))
+
+			networkData, err := libnet.NewNetworkData(
+				libnet.WithEthernet("eth0", libnet.WithDHCP4Enabled()),
+				libnet.WithEthernet("eth1", libnet.WithDHCP4Enabled(),
+					libnet.WithRoutingTable("eth1", tableA, nil, []libnet.Rule{{From: "eth1"}})),
+				libnet.WithEthernet("eth2", libnet.WithDHCP4Enabled(),
+					libnet.WithRoutingTable("eth2", tableB, nil, []libnet.Rule{{From: "eth2"}})),
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi := libvmi.NewFedora(
+				libvmi.WithInterface(*v1.DefaultBridgeNetworkInterface()),
+				libvmi.WithNetwork(v1.DefaultPodNetwork()),
+				secondaryA,
+				secondaryB,
+				libvmi.WithCloudInitNoCloudNetworkData(networkData, false),
+			)
+
+			vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+			Expect(err).ToNot(HaveOccurred())
+			vmi = tests.WaitUntilVMIReady(vmi, console.LoginToFedora)
+
+			Expect(vmi.Spec.Domain.Devices.Interfaces).To(HaveLen(3))
+
+			for _, ifaceName := range []string{"eth1", "eth2"} {
+				By(fmt.Sprintf("checking a route to 8.8.8.8 resolves out of %s", ifaceName))
+				err = console.SafeExpectBatch(vmi, []expect.Batcher{
+					&expect.BSnd{S: "\n"},
+					&expect.BExp{R: console.PromptExpression},
+					&expect.BSnd{S: fmt.Sprintf("ip route get 8.8.8.8 oif %s\n", ifaceName)},
+					&expect.BExp{R: fmt.Sprintf(".*dev %s.*", ifaceName)},
+				}, 30)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+
+		When("performing migration", func() {
+			It("should preserve all interface IPs", func() {
+				checks.SkipIfMigrationIsNotPossible()
+				libnet.SkipWhenClusterNotSupportIpv4(virtClient)
+
+				secondaryA, _ := libvmi.WithSecondaryInterface("eth1", "secondary-net-a", libvmi.WithRouteTable(101))
+				vmi := libvmi.NewFedora(
+					libvmi.WithInterface(*v1.DefaultBridgeNetworkInterface()),
+					libvmi.WithNetwork(v1.DefaultPodNetwork()),
+					secondaryA,
+				)
+
+				vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+				Expect(err).ToNot(HaveOccurred())
+				vmi = tests.WaitUntilVMIReady(vmi, console.LoginToFedora)
+				ipsBeforeMigration := vmi.Status.Interfaces
+
+				migration := tests.NewRandomMigration(vmi.Name, vmi.Namespace)
+				migration, err = virtClient.VirtualMachineInstanceMigration(migration.Namespace).Create(migration, &metav1.CreateOptions{})
+				Expect(err).ToNot(HaveOccurred())
+
+				Eventually(func() v1.VirtualMachineInstanceMigrationPhase {
+					migration, err := virtClient.VirtualMachineInstanceMigration(migration.Namespace).Get(migration.Name, &v13.GetOptions{})
+					Expect(err).ToNot(HaveOccurred())
+					return migration.Status.Phase
+				}, tests.MigrationWaitTime, time.Second).Should(Equal(v1.MigrationSucceeded))
+
+				vmi, err = virtClient.VirtualMachineInstance(vmi.Namespace).Get(vmi.Name, &v13.GetOptions{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(len(vmi.Status.Interfaces)).To(Equal(len(ipsBeforeMigration)))
+				for i, iface := range vmi.Status.Interfaces {
+					Expect(iface.IP).To(Equal(ipsBeforeMigration[i].IP))
+				}
+			})
+		})
+	})
+
 	Context("[Serial]vmi with default bridge interface on pod network", func() {
 		BeforeEach(func() {
 			setBridgeEnabled(false)
@@ -1102,3 +1718,61 @@ func vmiWithCustomMacAddress(mac string) *v1.VirtualMachineInstance {
 		libvmi.WithInterface(*libvmi.InterfaceWithMac(v1.DefaultBridgeNetworkInterface(), mac)),
 		libvmi.WithNetwork(v1.DefaultPodNetwork()))
 }
+
+// networkStatusAnnotation is the Multus annotation carrying the per-network
+// status (IPs, MAC, MTU, gateway) of every interface attached to a pod.
+const networkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+
+// podNetworkStatusEntry mirrors a single entry of the Multus
+// k8s.v1.cni.cncf.io/network-status annotation.
+type podNetworkStatusEntry struct {
+	Name      string   `json:"name"`
+	Interface string   `json:"interface"`
+	IPs       []string `json:"ips"`
+	Mac       string   `json:"mac"`
+	Mtu       int      `json:"mtu"`
+	Default   bool     `json:"default"`
+	Gateway   []string `json:"gateway,omitempty"`
+}
+
+// podPrimaryNetworkStatus returns the network-status entry describing the
+// pod's default (primary, eth0) interface, which may be backed by a
+// user-defined network NAD rather than the cluster's default CNI.
+func podPrimaryNetworkStatus(pod *k8sv1.Pod) (*podNetworkStatusEntry, error) {
+	raw, exists := pod.Annotations[networkStatusAnnotation]
+	if !exists {
+		return nil, fmt.Errorf("pod %s/%s has no %s annotation", pod.Namespace, pod.Name, networkStatusAnnotation)
+	}
+
+	var statuses []podNetworkStatusEntry
+	if err := json.Unmarshal([]byte(raw), &statuses); err != nil {
+		return nil, fmt.Errorf("failed to parse %s annotation: %v", networkStatusAnnotation, err)
+	}
+
+	for i := range statuses {
+		if statuses[i].Default {
+			return &statuses[i], nil
+		}
+	}
+	return nil, fmt.Errorf("pod %s/%s has no default interface in its %s annotation", pod.Namespace, pod.Name, networkStatusAnnotation)
+}
+
+// runVMIAndExpectUDNAttachment creates and waits for a VMI configured with
+// libvmi.WithPrimaryUserDefinedNetwork, then verifies the pod's primary
+// interface was indeed satisfied by the requested NAD rather than the
+// cluster's default CNI.
+func runVMIAndExpectUDNAttachment(virtClient kubecli.KubevirtClient, vmi *v1.VirtualMachineInstance) *v1.VirtualMachineInstance {
+	nadName, err := libvmi.PrimaryUserDefinedNetworkName(vmi)
+	Expect(err).ToNot(HaveOccurred())
+
+	vmi, err = virtClient.VirtualMachineInstance(util.NamespaceTestDefault).Create(vmi)
+	Expect(err).ToNot(HaveOccurred())
+	vmi = tests.WaitUntilVMIReady(vmi, console.LoginToCirros)
+
+	vmiPod := tests.GetRunningPodByVirtualMachineInstance(vmi, util.NamespaceTestDefault)
+	status, err := podPrimaryNetworkStatus(vmiPod)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(status.Name).To(ContainSubstring(nadName), "the primary interface should be attached to the requested user-defined network")
+
+	return vmi
+}